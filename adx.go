@@ -0,0 +1,74 @@
+// Azure Data Explorer (Kusto) output
+
+// Readings are sent with streaming ingestion as JSON lines in the same schema as
+// the jsonl output, through an ingestion mapping to a table of
+//   (Time: datetime, Measurement: string, Tags: dynamic, Fields: dynamic)
+// The README has the commands to create the table and mapping.  Streaming
+// ingestion must be enabled on the cluster and the table.  Credentials come from
+// azidentity's default chain, e.g. AZURE_CLIENT_ID/AZURE_TENANT_ID/
+// AZURE_CLIENT_SECRET, a managed identity or `az login`, and are kept from one
+// write to the next (see outputConns.go), along with the token they give.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	adxClusterPtr  = flag.String("adx-cluster", "", "Azure Data Explorer cluster URL, e.g. https://mycluster.westeurope.kusto.windows.net")
+	adxDatabasePtr = flag.String("adx-database", "envoy", "Azure Data Explorer database")
+	adxTablePtr    = flag.String("adx-table", "Readings", "Azure Data Explorer table")
+	adxMappingPtr  = flag.String("adx-mapping", "envoy_json", "Azure Data Explorer JSON ingestion mapping")
+)
+
+var adxConn = newOutputConn(func(ctx context.Context) (*azidentity.DefaultAzureCredential, error) {
+	return azidentity.NewDefaultAzureCredential(nil)
+}, func(*azidentity.DefaultAzureCredential) {})
+
+func writeADX(ctx context.Context, pts []*client.Point) error {
+	return adxConn.use(ctx, func(cred *azidentity.DefaultAzureCredential) error {
+		return ingestADX(ctx, cred, pts)
+	})
+}
+
+func ingestADX(ctx context.Context, cred *azidentity.DefaultAzureCredential, pts []*client.Point) error {
+	cluster := strings.TrimRight(*adxClusterPtr, "/")
+	// The credential keeps the token until it's about to expire
+	token, err := cred.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{cluster + "/.default"}})
+	if err != nil {
+		return err
+	}
+
+	var lines bytes.Buffer
+	if err := encodeJSONLines(&lines, pts); err != nil {
+		return err
+	}
+	params := url.Values{
+		"streamFormat": {"MultiJSON"},
+		"mappingName":  {*adxMappingPtr},
+	}
+	gzipped, err := gzipBody(lines.Bytes())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		cluster+"/v1/rest/ingest/"+url.PathEscape(*adxDatabasePtr)+"/"+url.PathEscape(*adxTablePtr)+"?"+params.Encode(),
+		gzipped)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("x-ms-app", "influxEnvoyStats")
+	_, err = doOutputRequest(ctx, req)
+	return err
+}
@@ -0,0 +1,48 @@
+// Anomaly detection on household consumption
+
+// Looks for a sudden sustained jump in base load, e.g. a failed fridge compressor
+// running continuously or a forgotten space heater.  The usual base load is the
+// 10th percentile of total consumption over the past week, and an anomaly is
+// when even the minimum over the anomaly window is well above it; short spikes
+// such as the kettle don't count.  The statistics are computed from the stored
+// readings each run, and an anomaly is published as an alert (which is written
+// to the events measurement, e.g. for Grafana annotations) once per window.
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// detectAnomalies publishes an alert if consumption's base load has jumped
+func detectAnomalies(ctx context.Context) error {
+	c, err := newInfluxClient()
+	if err != nil {
+		return err
+	}
+
+	window := *anomalyWindowPtr
+	measurement, field, where := powerSeries("total-consumption")
+	baseline, ok, err := queryFloat(c, fmt.Sprintf(`SELECT percentile(%q, 10) FROM %q WHERE %s AND time > now() - 7d AND time <= now() - %s`,
+		field, measurement, where, influxDuration(window)))
+	if err != nil || !ok {
+		return err
+	}
+	floor, ok, err := queryFloat(c, fmt.Sprintf(`SELECT min(%q) FROM %q WHERE %s AND time > now() - %s`,
+		field, measurement, where, influxDuration(window)))
+	if err != nil || !ok || floor < baseline+*anomalyWattsPtr {
+		return err
+	}
+
+	// Only raise the event once while the anomaly persists
+	values, err := queryValues(c, fmt.Sprintf(`SELECT last("title") FROM %q WHERE "type" = 'anomaly' AND time > now() - %s`, eventsMeasurement, influxDuration(window)))
+	if err != nil || len(values) > 0 {
+		return err
+	}
+
+	title := "Consumption base load jump"
+	text := fmt.Sprintf("Consumption has stayed above %.0f W for %s, usual base load is %.0f W", floor, window, baseline)
+	fmt.Fprintln(console, title+": "+text)
+	return publish(ctx, busEvent{Kind: alertEvent, Type: "anomaly", Title: title, Text: text})
+}
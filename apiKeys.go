@@ -0,0 +1,116 @@
+// API keys
+
+// With -api-keys, the addresses the daemon serves on (-prometheus-listen,
+// -status-listen and -pprof-listen) need a named key with the scope for what's
+// asked for, given as Authorization: Bearer <key>, or as the password with
+// basic auth, so they can be exposed beyond localhost with each family member
+// or automation given their own key:
+//   read    the readings, on /metrics
+//   status  /healthz and /status, which summarises the configuration
+//   admin   pprof profiles and runtime metrics, and everything else
+// Keys are read for each request, so a reload can change them.  There's
+// nothing to control the Envoy with, so no scope for that.  Without
+// -api-keys, anyone who can reach the addresses can use them.
+
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+)
+
+var apiKeysPtr = flag.String("api-keys", "", "Comma separated name=key:scope+scope API keys needed to use the addresses served on, scopes read, status or admin, e.g. grafana=s3cret:read (or API_KEYS / API_KEYS_FILE)")
+
+// Scopes an API key can have
+var apiScopes = map[string]bool{"read": true, "status": true, "admin": true}
+
+// apiKey is one of -api-keys
+type apiKey struct {
+	name   string
+	key    string
+	scopes map[string]bool
+}
+
+// parseAPIKeys parses -api-keys
+func parseAPIKeys() ([]apiKey, error) {
+	mappings, err := mappingList("api-keys", *apiKeysPtr)
+	if err != nil {
+		return nil, err
+	}
+	keys := []apiKey{}
+	for _, name := range sortedKeys(mappings) {
+		key, scopes, ok := strings.Cut(mappings[name], ":")
+		if !ok || key == "" {
+			return nil, configError("-api-keys %s needs a key and scopes, e.g. %s=s3cret:read", name, name)
+		}
+		k := apiKey{name: name, key: key, scopes: map[string]bool{}}
+		for _, scope := range strings.Split(scopes, "+") {
+			if !apiScopes[scope] {
+				return nil, configError("-api-keys %s: unknown scope %q, use read, status or admin", name, scope)
+			}
+			k.scopes[scope] = true
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// setupAPIKeys checks -api-keys
+func setupAPIKeys() error {
+	_, err := parseAPIKeys()
+	return err
+}
+
+// allows is whether the key can be used for scope
+func (k apiKey) allows(scope string) bool {
+	return k.scopes[scope] || k.scopes["admin"]
+}
+
+// requireScope has a handler need an API key with scope, if there are any
+func requireScope(scope string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settingsMu.RLock()
+		keys, err := parseAPIKeys()
+		settingsMu.RUnlock()
+		if err != nil {
+			http.Error(w, "API keys misconfigured", http.StatusInternalServerError)
+			return
+		}
+		if len(keys) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		k, ok := requestAPIKey(r, keys)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="influxEnvoyStats"`)
+			http.Error(w, "API key needed", http.StatusUnauthorized)
+			return
+		}
+		if !k.allows(scope) {
+			log.Printf(journalPriority(4, "API key %s used for %s without the %s scope"), k.name, r.URL.Path, scope)
+			http.Error(w, "API key "+k.name+" doesn't have the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestAPIKey is the key a request was made with, if any
+func requestAPIKey(r *http.Request, keys []apiKey) (apiKey, bool) {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, given, ok = r.BasicAuth()
+	}
+	if !ok || given == "" {
+		return apiKey{}, false
+	}
+	for _, k := range keys {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(k.key)) == 1 {
+			return k, true
+		}
+	}
+	return apiKey{}, false
+}
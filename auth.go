@@ -0,0 +1,94 @@
+// Envoy authentication
+
+// Firmware 7+ Envoys require an access token (obtained from Enphase) on every
+// request, over HTTPS.  Older firmware serves production.json openly but uses
+// HTTP digest authentication for the installer/inverter APIs.
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+func envoyScheme() string {
+	if *envoyTokenPtr != "" {
+		return "https"
+	}
+	return "http"
+}
+
+type envoyAuthTransport struct {
+	base http.RoundTripper
+}
+
+func (t *envoyAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *envoyTokenPtr != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+*envoyTokenPtr)
+		return t.base.RoundTrip(req)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || *envoyPwPtr == "" {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	// Only GETs are made to the Envoy so the request can simply be resent
+	req = req.Clone(req.Context())
+	authorization, err := digestAuthorization(req, challenge)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+	return t.base.RoundTrip(req)
+}
+
+var digestParamRegexp = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+
+// digestAuthorization answers a digest challenge as per RFC 2617
+func digestAuthorization(req *http.Request, challenge string) (string, error) {
+	params := map[string]string{}
+	for _, m := range digestParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2] + m[3]
+	}
+
+	uri := req.URL.RequestURI()
+	ha1 := md5Hex(*envoyUserPtr + ":" + params["realm"] + ":" + *envoyPwPtr)
+	ha2 := md5Hex(req.Method + ":" + uri)
+
+	authorization := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		*envoyUserPtr, params["realm"], params["nonce"], uri)
+	if params["qop"] == "" {
+		authorization += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+params["nonce"]+":"+ha2))
+	} else {
+		cnonceBytes := make([]byte, 8)
+		_, err := rand.Read(cnonceBytes)
+		if err != nil {
+			return "", err
+		}
+		cnonce := hex.EncodeToString(cnonceBytes)
+		nc := "00000001"
+		response := md5Hex(ha1 + ":" + params["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+		authorization += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s", response="%s"`, nc, cnonce, response)
+	}
+	if params["opaque"] != "" {
+		authorization += fmt.Sprintf(`, opaque="%s"`, params["opaque"])
+	}
+	return authorization, nil
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
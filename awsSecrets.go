@@ -0,0 +1,112 @@
+// Credentials from AWS Secrets Manager and SSM Parameter Store
+
+// Any setting can be given as a reference which is resolved at startup, using
+// the standard AWS credential chain (e.g. the EC2/ECS IAM role):
+//   aws-sm://mysecret/envoy_token   the envoy_token key of JSON secret mysecret
+//   aws-sm://mysecret               the whole of secret mysecret
+//   aws-ssm:///solar/db_password    the (decrypted) parameter /solar/db_password
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"strings"
+)
+
+const (
+	awsSecretsManagerScheme = "aws-sm://"
+	awsParameterStoreScheme = "aws-ssm://"
+)
+
+// References by flag name, kept to resolve again on reload
+var awsSecretRefs = map[string]string{}
+
+func secretsFromAWS(ctx context.Context) error {
+	// Flags still holding a reference, rather than what it resolved to
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if strings.HasPrefix(value, awsSecretsManagerScheme) || strings.HasPrefix(value, awsParameterStoreScheme) {
+			awsSecretRefs[f.Name] = value
+		}
+	})
+	refs := awsSecretRefs
+	if len(refs) == 0 {
+		return nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("loading the AWS configuration: %w", err)
+	}
+	secrets := map[string]string{}
+	for name, ref := range refs {
+		var value string
+		if strings.HasPrefix(ref, awsSecretsManagerScheme) {
+			value, err = awsSecret(ctx, cfg, secrets, strings.TrimPrefix(ref, awsSecretsManagerScheme))
+		} else {
+			var out *ssm.GetParameterOutput
+			out, err = ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{
+				Name:           aws.String(strings.TrimPrefix(ref, awsParameterStoreScheme)),
+				WithDecryption: aws.Bool(true),
+			})
+			if err == nil {
+				value = aws.ToString(out.Parameter.Value)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("-%s %s: %w", name, ref, err)
+		}
+		registerSecret(value)
+		if err := flag.Set(name, value); err != nil {
+			return configError("-%s %s: %v", name, ref, err)
+		}
+	}
+	return nil
+}
+
+// awsSecret resolves a Secrets Manager reference, fetching each secret once
+func awsSecret(ctx context.Context, cfg aws.Config, secrets map[string]string, ref string) (string, error) {
+	id, key := ref, ""
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		id, key = ref[:i], ref[i+1:]
+	}
+
+	secret, ok := secrets[id]
+	if !ok {
+		out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(id),
+		})
+		if err != nil && key != "" {
+			// The whole reference may be the secret name, which can contain /
+			id, key = ref, ""
+			out, err = secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+				SecretId: aws.String(id),
+			})
+		}
+		if err != nil {
+			return "", err
+		}
+		secret = aws.ToString(out.SecretString)
+		secrets[id] = secret
+	}
+	if key == "" {
+		return secret, nil
+	}
+
+	keys := map[string]string{}
+	if err := json.Unmarshal([]byte(secret), &keys); err != nil {
+		return "", fmt.Errorf("secret %s isn't JSON with key %q: %w", id, key, err)
+	}
+	value, ok := keys[key]
+	if !ok {
+		return "", fmt.Errorf("no key %q in secret %s", key, id)
+	}
+	return value, nil
+}
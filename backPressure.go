@@ -0,0 +1,65 @@
+// Back-pressure
+
+// When an output falls behind, a daemon collects less often rather than
+// piling up points it can't write, e.g. on a Raspberry Pi writing to a remote
+// InfluxDB over a poor link.  After each collection, if an output's last write
+// took longer than -slow-write, or more than -slow-queue points are waiting to
+// be written to one (buffered or spooled), the wait until a collector's next
+// collection is doubled, up to -max-stretch times its interval.  Once the
+// outputs keep up again it's halved each collection, back to the interval.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	slowWritePtr  = flag.Duration("slow-write", 10*time.Second, "Collect less often while an output's last write took longer than this (0 disables)")
+	slowQueuePtr  = flag.Int("slow-queue", 1000, "Collect less often while more than this many points are waiting to be written to an output (0 disables)")
+	maxStretchPtr = flag.Int("max-stretch", 8, "Most times longer than its interval a collector waits while outputs fall behind (1 disables)")
+)
+
+// backPressure says which output is falling behind, and why, if one is
+func backPressure() string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	for _, o := range enabledOutputs {
+		status := o.status()
+		if *slowQueuePtr > 0 && status.Buffered > *slowQueuePtr {
+			return fmt.Sprintf("%d points are waiting for output %s", status.Buffered, o.name)
+		}
+		if took := o.lastTook(); *slowWritePtr > 0 && took > *slowWritePtr {
+			return fmt.Sprintf("output %s took %s to write", o.name, took.Round(time.Millisecond))
+		}
+	}
+	return ""
+}
+
+// adaptStretch doubles how many times its interval a collector waits while
+// outputs fall behind, and halves it once they keep up
+func adaptStretch(name string, stretch int) int {
+	if reason := backPressure(); reason != "" {
+		if stretch < *maxStretchPtr {
+			stretch *= 2
+			if stretch > *maxStretchPtr {
+				stretch = *maxStretchPtr
+			}
+			log.Printf(journalPriority(4, "Collecting %s at %dx its interval, as %s"), name, stretch, reason)
+		}
+		return stretch
+	}
+	if stretch > 1 {
+		stretch /= 2
+		log.Printf("Outputs are keeping up, collecting %s at %dx its interval", name, stretch)
+	}
+	return stretch
+}
+
+// stretched is when to collect next, with the wait until next stretched
+func stretched(start time.Time, next func(time.Time) time.Time, stretch int) time.Time {
+	return start.Add(next(start).Sub(start) * time.Duration(stretch))
+}
@@ -0,0 +1,59 @@
+// Batching across collections
+
+// In daemon mode, -flush-interval and -batch-size hold collected points back
+// and write them together, every so often or once there are enough, so
+// outputs are written less often, e.g. when collecting every few seconds over
+// a slow link.  Held back points are written when the daemon stops.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+	"time"
+)
+
+var (
+	flushIntervalPtr = flag.Duration("flush-interval", 0, "In daemon mode, write collected points to outputs this often rather than every collection, e.g. 5m")
+	batchSizePtr     = flag.Int("batch-size", 0, "In daemon mode, write collected points to outputs once there are this many rather than every collection")
+)
+
+var (
+	batch     []*client.Point
+	lastFlush time.Time
+)
+
+// batchPoints adds points to the batch, writing it to the outputs if it's due
+func batchPoints(ctx context.Context, pts []*client.Point) error {
+	if lastFlush.IsZero() {
+		lastFlush = time.Now()
+	}
+	batch = append(batch, pts...)
+	if !daemonMode() ||
+		*flushIntervalPtr == 0 && *batchSizePtr == 0 ||
+		*batchSizePtr > 0 && len(batch) >= *batchSizePtr ||
+		*flushIntervalPtr > 0 && time.Since(lastFlush) >= *flushIntervalPtr {
+		return writeOutputs(ctx, takeBatch())
+	}
+	return nil
+}
+
+func takeBatch() []*client.Point {
+	pts := batch
+	batch = nil
+	lastFlush = time.Now()
+	return pts
+}
+
+// flushBatch writes any points held back, e.g. when stopping
+func flushBatch(ctx context.Context) {
+	if len(batch) == 0 {
+		return
+	}
+	log.Printf("Writing %d batched points", len(batch))
+	if err := writeOutputs(ctx, takeBatch()); err != nil {
+		log.Printf(journalPriority(3, "Writing batched points: %v"), err)
+	}
+}
@@ -0,0 +1,149 @@
+// Battery analytics
+
+// With -battery-summary, a day's battery readings are read back from InfluxDB
+// and summarized as a point in the battery_daily measurement: the energy
+// charged and discharged (integrated from the battery's power, negative while
+// charging), the equivalent full cycles that makes, given the capacity implied
+// by its stored energy and state of charge, the total cycles so far, and the
+// round-trip efficiency, the energy discharged and left stored for that
+// charged.  Run it each night from cron, e.g.
+// > influxEnvoyStats -battery-summary yesterday
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"math"
+	"time"
+)
+
+var batterySummaryPtr = flag.String("battery-summary", "", "Instead of polling the Envoy, write a day's battery charge and discharge energy, cycles and round-trip efficiency (YYYY-MM-DD or \"yesterday\") to the battery_daily measurement")
+
+const batteryDailyMeasurement = "battery_daily"
+
+// Least energy charged in a day to work out the round-trip efficiency from
+const batteryMinChargeWh = 100
+
+// batterySample is a battery reading read back from InfluxDB
+type batterySample struct {
+	t       time.Time
+	watts   float64
+	wh      float64
+	percent float64
+}
+
+func storeBatterySummary(date string) error {
+	day, err := parseDay(date)
+	if err != nil {
+		return err
+	}
+	c, err := newInfluxClient()
+	if err != nil {
+		return err
+	}
+
+	measurement, fields, where := batterySeries()
+	rows, err := queryValues(c, fmt.Sprintf(`SELECT %q, %q, %q FROM %q WHERE %stime >= '%s' AND time < '%s'`,
+		fields[0], fields[1], fields[2], measurement, where, day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339)))
+	if err != nil {
+		return err
+	}
+	samples := []batterySample{}
+	for _, row := range rows {
+		t, err := time.Parse(time.RFC3339Nano, row[0].(string))
+		if err != nil {
+			return fmt.Errorf("battery reading time: %w", err)
+		}
+		samples = append(samples, batterySample{t, jsonFloat(row[1]), jsonFloat(row[2]), jsonFloat(row[3])})
+	}
+	if len(samples) < 2 {
+		fmt.Printf("%s: no battery readings\n", day.Format("2006-01-02"))
+		return nil
+	}
+
+	chargedWh, dischargedWh := 0.0, 0.0
+	capacityWh := 0.0
+	for i, s := range samples {
+		// Near empty, the state of charge is too coarse to tell the capacity by
+		if s.percent > 5 {
+			capacityWh = math.Max(capacityWh, s.wh/(s.percent/100))
+		}
+		if i == 0 {
+			continue
+		}
+		prev := samples[i-1]
+		if dt := s.t.Sub(prev.t); dt <= gridMaxGap {
+			chargedWh += (math.Max(-prev.watts, 0) + math.Max(-s.watts, 0)) / 2 * dt.Hours()
+			dischargedWh += (math.Max(prev.watts, 0) + math.Max(s.watts, 0)) / 2 * dt.Hours()
+		}
+	}
+	previousCycles, err := previousBatteryCycles(c, day)
+	if err != nil {
+		return err
+	}
+	summary, text, err := batterySummaryPoint(day, samples[0].wh, samples[len(samples)-1].wh, chargedWh, dischargedWh, capacityWh, previousCycles)
+	if err != nil {
+		return err
+	}
+	if err := writePoints(context.Background(), []*client.Point{summary}); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", day.Format("2006-01-02"), text)
+	return nil
+}
+
+// batterySummaryPoint builds the battery_daily point for a day, with a
+// description of it
+func batterySummaryPoint(day time.Time, startWh, endWh, chargedWh, dischargedWh, capacityWh, previousCycles float64) (*client.Point, string, error) {
+	fields := map[string]interface{}{
+		"charged_wh":    chargedWh,
+		"discharged_wh": dischargedWh,
+	}
+	text := fmt.Sprintf("charged %.0f Wh, discharged %.0f Wh", chargedWh, dischargedWh)
+	if capacityWh > 0 {
+		cycles := dischargedWh / capacityWh
+		fields["capacity_wh"] = capacityWh
+		fields["cycles"] = cycles
+		fields["cycles_total"] = previousCycles + cycles
+		text += fmt.Sprintf(", %.2f cycles of %.0f Wh", cycles, capacityWh)
+	}
+	if chargedWh >= batteryMinChargeWh {
+		efficiency := (dischargedWh + endWh - startWh) / chargedWh * 100
+		fields["round_trip_efficiency_percent"] = efficiency
+		text += fmt.Sprintf(", %.1f%% round-trip efficiency", efficiency)
+	}
+	pt, err := newPoint(batteryDailyMeasurement, nil, fields, day)
+	return pt, text, err
+}
+
+// previousBatteryCycles is the total of the cycles summarized before a day
+func previousBatteryCycles(c client.Client, day time.Time) (float64, error) {
+	cycles, _, err := queryFloat(c, fmt.Sprintf(`SELECT sum("cycles") FROM %q WHERE time < '%s'`, batteryDailyMeasurement, day.Format(time.RFC3339)))
+	return cycles, err
+}
+
+// batterySeries gives the measurement, power, stored energy and charge fields,
+// and condition, to query battery readings with, as written with -schema and
+// any renames
+func batterySeries() (string, [3]string, string) {
+	if *schemaPtr == 2 {
+		names := currentRenames().series("batteries", nil)
+		return names.measurement, [3]string{names.field("power_w"), names.field("energy_wh"), names.field("charge_percent")}, ""
+	}
+	names := currentRenames().series(readingsMeasurement(), map[string]string{"type": "storage"})
+	return names.measurement, [3]string{names.field("watts"), names.field("wh_now"), names.field("percent_full")}, names.where() + " AND "
+}
+
+// jsonFloat is a number from a query, or zero if it's missing
+func jsonFloat(value interface{}) float64 {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0
+	}
+	f, _ := n.Float64()
+	return f
+}
@@ -0,0 +1,154 @@
+// Google BigQuery output
+
+// Each measurement is streamed into a table of the same name in
+// -bigquery-dataset, created day-partitioned on time, e.g.
+//   readings (time TIMESTAMP, type STRING, watts FLOAT, wh_today FLOAT)
+// with columns added as new tags or fields appear.  Rows carry an insert ID of
+// their series and time, so BigQuery drops duplicates if a write is retried.
+// Credentials come from Application Default Credentials, e.g.
+// GOOGLE_APPLICATION_CREDENTIALS or the GCE metadata server.  The client is
+// kept from one write to the next (see outputConns.go).
+
+package main
+
+import (
+	"cloud.google.com/go/bigquery"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"google.golang.org/api/googleapi"
+	"net/http"
+)
+
+var (
+	bigQueryProjectPtr = flag.String("bigquery-project", "", "Google Cloud project for BigQuery (default from credentials)")
+	bigQueryDatasetPtr = flag.String("bigquery-dataset", "envoy", "BigQuery dataset, which must exist")
+)
+
+// Rows per streaming insert request, below BigQuery's recommended maximum
+const bigQueryBatch = 500
+
+type bigQueryRow struct {
+	insertID string
+	values   map[string]bigquery.Value
+}
+
+func (r bigQueryRow) Save() (map[string]bigquery.Value, string, error) {
+	return r.values, r.insertID, nil
+}
+
+var bigQueryConn = newOutputConn(func(ctx context.Context) (*bigquery.Client, error) {
+	project := *bigQueryProjectPtr
+	if project == "" {
+		project = bigquery.DetectProjectID
+	}
+	// Not the write's context, as the client refreshes its credentials with it
+	return bigquery.NewClient(context.Background(), project)
+}, func(bq *bigquery.Client) {
+	bq.Close()
+})
+
+func writeBigQuery(ctx context.Context, pts []*client.Point) error {
+	return bigQueryConn.use(ctx, func(bq *bigquery.Client) error {
+		return insertBigQuery(ctx, bq, pts)
+	})
+}
+
+func insertBigQuery(ctx context.Context, bq *bigquery.Client, pts []*client.Point) error {
+	tables := map[string][]*client.Point{}
+	for _, pt := range pts {
+		tables[pt.Name()] = append(tables[pt.Name()], pt)
+	}
+
+	for _, name := range sortedKeys(tables) {
+		columnTypes := map[string]bigquery.FieldType{}
+		rows := []*bigQueryRow{}
+		for _, pt := range tables[name] {
+			fields, err := pt.Fields()
+			if err != nil {
+				return err
+			}
+			values := map[string]bigquery.Value{"time": pt.Time()}
+			for k, v := range fields {
+				if _, ok := columnTypes[k]; !ok {
+					columnTypes[k] = bigQueryType(v)
+				}
+				values[k] = v
+			}
+			for k, v := range pt.Tags() {
+				columnTypes[k] = bigquery.StringFieldType
+				values[k] = v
+			}
+			rows = append(rows, &bigQueryRow{
+				insertID: fmt.Sprintf("%s %d", seriesKey(pt), pt.Time().UnixNano()),
+				values:   values,
+			})
+		}
+
+		table := bq.Dataset(*bigQueryDatasetPtr).Table(name)
+		if err := bigQueryTable(ctx, table, columnTypes); err != nil {
+			return err
+		}
+		inserter := table.Inserter()
+		for start := 0; start < len(rows); start += bigQueryBatch {
+			end := start + bigQueryBatch
+			if end > len(rows) {
+				end = len(rows)
+			}
+			if err := inserter.Put(ctx, rows[start:end]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bigQueryTable creates the table if it doesn't exist, or adds any columns it's
+// missing
+func bigQueryTable(ctx context.Context, table *bigquery.Table, columnTypes map[string]bigquery.FieldType) error {
+	schema := bigquery.Schema{{Name: "time", Type: bigquery.TimestampFieldType, Required: true}}
+	for _, column := range sortedKeys(columnTypes) {
+		schema = append(schema, &bigquery.FieldSchema{Name: column, Type: columnTypes[column]})
+	}
+
+	md, err := table.Metadata(ctx)
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		return table.Create(ctx, &bigquery.TableMetadata{
+			Schema:           schema,
+			TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "time"},
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, field := range md.Schema {
+		existing[field.Name] = true
+	}
+	updated := md.Schema
+	for _, field := range schema {
+		if !existing[field.Name] {
+			updated = append(updated, field)
+		}
+	}
+	if len(updated) > len(md.Schema) {
+		_, err = table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: updated}, md.ETag)
+	}
+	return err
+}
+
+func bigQueryType(v interface{}) bigquery.FieldType {
+	switch v.(type) {
+	case float64:
+		return bigquery.FloatFieldType
+	case int64:
+		return bigquery.IntegerFieldType
+	case bool:
+		return bigquery.BooleanFieldType
+	}
+	return bigquery.StringFieldType
+}
@@ -0,0 +1,111 @@
+// Sanity bounds
+
+// Envoys occasionally report garbage, e.g. negative production, multi-megawatt
+// spikes or timestamps in 1970.  Collected fields outside their -bounds are
+// dropped along with their point, or with -out-of-bounds clamp, limited to the
+// bound, and points timestamped before 2010 or more than -max-future ahead are
+// dropped, each logged, so they don't pollute dashboards.
+
+package main
+
+import (
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	boundsPtr       = flag.String("bounds", "production.watts=-100:1000000,total-consumption.watts=-100:1000000,net-consumption.watts=-1000000:1000000,storage.percent_full=0:100", "Valid ranges of collected fields, as comma separated type.field=min:max (either may be left out)")
+	outOfBoundsPtr  = flag.String("out-of-bounds", "drop", "What to do with a point with a field outside -bounds: drop, or clamp the field to the bound")
+	maxFuturePtr    = flag.Duration("max-future", time.Hour, "Drop points timestamped further than this ahead of the clock")
+	earliestReading = time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+type fieldBounds struct {
+	min, max float64
+}
+
+// Valid ranges by type.field
+var bounds = map[string]fieldBounds{}
+
+// setupBounds parses -bounds
+func setupBounds() error {
+	if *outOfBoundsPtr != "drop" && *outOfBoundsPtr != "clamp" {
+		return configError("unknown -out-of-bounds %q", *outOfBoundsPtr)
+	}
+	boundsList, err := mappingList("bounds", *boundsPtr)
+	if err != nil {
+		return err
+	}
+	for name, bound := range boundsList {
+		parts := strings.SplitN(bound, ":", 2)
+		if len(parts) != 2 {
+			return configError("invalid -bounds range %q, use min:max", bound)
+		}
+		b := fieldBounds{min: -1e308, max: 1e308}
+		for i, limit := range []*float64{&b.min, &b.max} {
+			if parts[i] == "" {
+				continue
+			}
+			v, err := strconv.ParseFloat(parts[i], 64)
+			if err != nil {
+				return configError("invalid -bounds range %q: %v", bound, err)
+			}
+			*limit = v
+		}
+		bounds[name] = b
+	}
+	return nil
+}
+
+// checkBounds drops or clamps points with values out of bounds
+func checkBounds(pts []*client.Point) ([]*client.Point, error) {
+	clamp := *outOfBoundsPtr == "clamp"
+
+	valid := pts[:0:0]
+	for _, pt := range pts {
+		if pt.Time().Before(earliestReading) || pt.Time().After(time.Now().Add(*maxFuturePtr)) {
+			log.Printf(journalPriority(4, "Dropping point with bad timestamp: %s"), pt)
+			continue
+		}
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		clamped := false
+		dropped := false
+		for field, value := range fields {
+			b, ok := bounds[pt.Tags()["type"]+"."+field]
+			v, numeric := numericValue(value)
+			if !ok || !numeric || v >= b.min && v <= b.max {
+				continue
+			}
+			if !clamp {
+				log.Printf(journalPriority(4, "Dropping point with %s out of bounds: %s"), field, pt)
+				dropped = true
+				break
+			}
+			bound := b.min
+			if v > b.max {
+				bound = b.max
+			}
+			log.Printf(journalPriority(4, "Clamping %s %s %v to %g"), pt.Tags()["type"], field, value, bound)
+			fields[field] = bound
+			clamped = true
+		}
+		if dropped {
+			continue
+		}
+		if clamped {
+			pt, err = newPoint(pt.Name(), pt.Tags(), fields, pt.Time())
+			if err != nil {
+				return nil, err
+			}
+		}
+		valid = append(valid, pt)
+	}
+	return valid, nil
+}
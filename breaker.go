@@ -0,0 +1,88 @@
+// Circuit breakers
+
+// After -breaker-failures failures in a row, the Envoy or an output is left
+// alone for -breaker-wait rather than being retried every collection.  Then
+// one attempt is let through as a probe, which closes the breaker if it
+// succeeds or opens it for another -breaker-wait if not.  Points for an output
+// whose breaker is open are buffered as for a failed write.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	breakerFailuresPtr = flag.Int("breaker-failures", 5, "Failures in a row after which to stop trying the Envoy or an output for -breaker-wait (0 disables)")
+	breakerWaitPtr     = flag.Duration("breaker-wait", time.Minute, "How long to leave the Envoy or an output alone after -breaker-failures, before trying it again")
+)
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"    // Working, or not failed enough to be left alone
+	breakerOpen     breakerState = "open"      // Being left alone until openUntil
+	breakerHalfOpen breakerState = "half-open" // Being probed
+)
+
+type circuitBreaker struct {
+	name      string
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(name string) *circuitBreaker {
+	return &circuitBreaker{name: name, state: breakerClosed}
+}
+
+// allow returns an error if the breaker is open, otherwise letting an attempt
+// through, as a probe if it's time to try again
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerClosed {
+		return nil
+	}
+	if b.state == breakerOpen && time.Now().Before(b.openUntil) {
+		return fmt.Errorf("not tried after %d failures in a row, until %s", b.failures, b.openUntil.Format("15:04:05"))
+	}
+	if b.state == breakerOpen {
+		log.Printf("Trying %s again", b.name)
+		b.state = breakerHalfOpen
+	}
+	return nil
+}
+
+// record notes how an attempt allowed through went
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.state != breakerClosed {
+			log.Printf("%s working again", b.name)
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if *breakerFailuresPtr > 0 && (b.state == breakerHalfOpen || b.failures >= *breakerFailuresPtr) {
+		b.state = breakerOpen
+		b.openUntil = time.Now().Add(*breakerWaitPtr)
+		log.Printf(journalPriority(4, "%s failed %d times in a row, leaving it alone until %s"), b.name, b.failures, b.openUntil.Format("15:04:05"))
+	}
+}
+
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+var envoyBreaker = newCircuitBreaker("Envoy")
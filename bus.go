@@ -0,0 +1,146 @@
+// Internal event bus
+
+// Collection publishes what happened during a run, and writers, alert rules and
+// hooks subscribe to the kinds of event they care about, rather than each being
+// called directly from main.  Handlers are called synchronously in the order
+// they subscribed, and publishing fails with whatever they failed with.  Alerts,
+// errors, the grid changing between importing and exporting (see grid.go) and
+// inverters going offline (see inverters.go) are passed on to the webhook and
+// exec outputs, if they're enabled, as hooks.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+	"time"
+)
+
+type eventKind string
+
+const (
+	dataReadyEvent       eventKind = "data ready"       // Points collected from the Envoy
+	alertEvent           eventKind = "alert"            // Something worth annotating, e.g. an anomaly
+	errorEvent           eventKind = "error"            // An error, e.g. just before giving up
+	gridStateEvent       eventKind = "grid state"       // The grid changed from importing to exporting, or back
+	inverterOfflineEvent eventKind = "inverter offline" // Inverters stopped reporting while others carried on
+	envoyLogEvent        eventKind = "envoy event"      // An event in the Envoy's own log
+)
+
+// Kinds of event passed on to hooks
+var hookEvents = []eventKind{alertEvent, errorEvent, gridStateEvent, inverterOfflineEvent}
+
+const eventsMeasurement = "events"
+
+type busEvent struct {
+	Kind   eventKind
+	Time   time.Time
+	Points []*client.Point
+	Type   string
+	Title  string
+	Text   string
+	Err    error
+}
+
+var subscribers = map[eventKind][]func(context.Context, busEvent) error{}
+
+func subscribe(kind eventKind, handler func(context.Context, busEvent) error) {
+	subscribers[kind] = append(subscribers[kind], handler)
+}
+
+// publish calls each handler of an event, even if earlier ones failed
+func publish(ctx context.Context, e busEvent) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	var errs []error
+	for _, handler := range subscribers[e.Kind] {
+		if err := handler(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// publishError tells error subscribers about an error, e.g. just before
+// giving up, once the collection's context may have ended
+func publishError(err error) {
+	if err := publish(context.Background(), busEvent{Kind: errorEvent, Type: "error", Err: err, Text: redactSecrets(err.Error())}); err != nil {
+		log.Printf(journalPriority(4, "Publishing error: %v"), err)
+	}
+}
+
+// notify publishes an event noticed while collecting, logging rather than
+// failing the collection if a handler fails
+func notify(ctx context.Context, e busEvent) {
+	if err := publish(ctx, e); err != nil {
+		log.Printf(journalPriority(4, "Publishing %s event: %v"), e.Kind, err)
+	}
+}
+
+// eventJSON is an event as hooks are given it
+type eventJSON struct {
+	Time  string `json:"time"`
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text"`
+}
+
+func newEventJSON(e busEvent) eventJSON {
+	return eventJSON{
+		Time:  e.Time.UTC().Format(time.RFC3339Nano),
+		Kind:  string(e.Kind),
+		Type:  e.Type,
+		Title: e.Title,
+		Text:  e.Text,
+	}
+}
+
+// hookEvent passes an event on to the webhook and exec outputs, if they're
+// enabled
+func hookEvent(ctx context.Context, e busEvent) error {
+	if *dryRunPtr {
+		return nil
+	}
+	var errs []error
+	for _, o := range enabledOutputs {
+		switch o.name {
+		case "webhook":
+			errs = append(errs, postWebhookEvent(ctx, e))
+		case "exec":
+			errs = append(errs, execEvent(ctx, e))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeEvent writes an alert as an event point, suitable for Grafana
+// annotations, unless it's already been written
+func writeEvent(ctx context.Context, e busEvent) error {
+	if !newEvent(e) {
+		return nil
+	}
+	pt, err := eventPoint(e)
+	if err != nil {
+		return err
+	}
+	if err := writePoints(ctx, []*client.Point{pt}); err != nil {
+		return fmt.Errorf("writing %s event: %w", e.Type, err)
+	}
+	return nil
+}
+
+func eventPoint(e busEvent) (*client.Point, error) {
+	tags := map[string]string{
+		"type": e.Type,
+	}
+	fields := map[string]interface{}{
+		"title": e.Title,
+		"text":  e.Text,
+	}
+	return newPoint(eventsMeasurement, tags, fields, e.Time)
+}
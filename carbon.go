@@ -0,0 +1,209 @@
+// CO2 avoided
+
+// With -carbon-intensity, the grid's carbon intensity in grams of CO2 per
+// kWh, production readings also get the CO2 that the energy produced since the
+// last reading avoided, co2_avoided_g, and since local midnight,
+// co2_avoided_g_today, with the intensity it was counted at,
+// grid_carbon_intensity.  The intensity can vary by time of day, as tariffs do
+// (see tariff.go), e.g. -carbon-intensity 400,17:00-21:00=550.  Or, with
+// -carbon-api, it's the grid's current intensity from ElectricityMaps or
+// WattTime (its marginal emissions), asked for at most every carbonRefresh,
+// falling back to -carbon-intensity when the service can't be reached.  Like
+// grid energy, the CO2 is counted between readings at most gridMaxGap apart,
+// and carries on across runs with -state-file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	carbonIntensityPtr = flag.String("carbon-intensity", "", "Grid carbon intensity in gCO2/kWh to count the CO2 production avoided with, flat or by time of day as -import-tariff, e.g. 400")
+	carbonAPIPtr       = flag.String("carbon-api", "", "Service to get the grid's current carbon intensity from: electricitymaps or watttime")
+	carbonZonePtr      = flag.String("carbon-zone", "", "With -carbon-api, the ElectricityMaps zone, e.g. DE, or WattTime region, e.g. CAISO_NORTH")
+	carbonUserPtr      = flag.String("carbon-user", "", "With -carbon-api watttime, the WattTime username")
+	carbonKeyPtr       = flag.String("carbon-key", "", "ElectricityMaps API token, or WattTime password (or CARBON_API_KEY / CARBON_API_KEY_FILE)")
+)
+
+const (
+	carbonRefresh      = 15 * time.Minute
+	electricityMapsURL = "https://api.electricitymap.org/v3/carbon-intensity/latest"
+	wattTimeURL        = "https://api.watttime.org"
+	// Grams in a pound, as WattTime gives lbs/MWh
+	gramsPerPound = 453.592
+)
+
+// carbonAvoided follows the CO2 production avoided today
+type carbonAvoided struct {
+	Day   string    `json:"day"`   // Local date counted, e.g. 2024-05-01
+	Last  time.Time `json:"last"`  // Time of the last reading
+	Watts float64   `json:"watts"` // Production at the last reading
+	Grams float64   `json:"grams"` // CO2 avoided today
+}
+
+// Services the carbon intensity can be had from, in gCO2/kWh
+var carbonAPIs = map[string]func(context.Context) (float64, error){
+	"electricitymaps": electricityMapsIntensity,
+	"watttime":        wattTimeIntensity,
+}
+
+var (
+	carbonSchedule *tariff
+	carbonClient   = http.Client{
+		Timeout: time.Second * 10,
+	}
+	// The last intensity from -carbon-api, when it was had, and when it was
+	// last asked for
+	carbonLatest  float64
+	carbonFetched time.Time
+	carbonAsked   time.Time
+)
+
+// setupCarbon parses -carbon-intensity and checks -carbon-api's settings
+func setupCarbon() error {
+	var err error
+	if carbonSchedule, err = parseTariff("carbon-intensity", *carbonIntensityPtr); err != nil {
+		return err
+	}
+	if *carbonAPIPtr == "" {
+		return nil
+	}
+	if carbonAPIs[*carbonAPIPtr] == nil {
+		return configError("unknown -carbon-api %q, use electricitymaps or watttime", *carbonAPIPtr)
+	}
+	if *carbonZonePtr == "" || *carbonKeyPtr == "" || *carbonAPIPtr == "watttime" && *carbonUserPtr == "" {
+		return configError("-carbon-api %s needs -carbon-zone and -carbon-key, and for WattTime -carbon-user", *carbonAPIPtr)
+	}
+	return nil
+}
+
+// carbonFields adds the CO2 avoided, and the carbon intensity, to
+// production's fields
+func carbonFields(ctx context.Context, fields map[string]interface{}, watts float64, at time.Time) {
+	intensity, ok := carbonIntensity(ctx, at)
+	if !ok {
+		return
+	}
+	watts = math.Max(watts, 0)
+	fields["grid_carbon_intensity"] = intensity
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	c := state.Carbon
+	grams := 0.0
+	if at.After(c.Last) {
+		if day := at.Local().Format("2006-01-02"); day != c.Day {
+			c.Day, c.Grams = day, 0
+		}
+		if dt := at.Sub(c.Last); !c.Last.IsZero() && dt <= gridMaxGap {
+			grams = (c.Watts + watts) / 2 * dt.Hours() / 1000 * intensity
+			c.Grams += grams
+		}
+		c.Last, c.Watts = at, watts
+		state.Carbon = c
+	}
+	fields["co2_avoided_g"] = grams
+	fields["co2_avoided_g_today"] = c.Grams
+}
+
+// carbonIntensity is the grid's carbon intensity at a time, if it's known
+func carbonIntensity(ctx context.Context, at time.Time) (float64, bool) {
+	if fetch := carbonAPIs[*carbonAPIPtr]; fetch != nil {
+		if time.Since(carbonAsked) >= carbonRefresh {
+			carbonAsked = time.Now()
+			latest, err := fetch(ctx)
+			if err == nil {
+				carbonLatest, carbonFetched = latest, time.Now()
+			} else {
+				log.Printf(journalPriority(4, "Couldn't get the carbon intensity from %s: %v"), *carbonAPIPtr, redactSecrets(err.Error()))
+			}
+		}
+		if !carbonFetched.IsZero() && time.Since(carbonFetched) < 2*carbonRefresh {
+			return carbonLatest, true
+		}
+	}
+	if carbonSchedule == nil {
+		return 0, false
+	}
+	return carbonSchedule.at(at), true
+}
+
+func electricityMapsIntensity(ctx context.Context) (float64, error) {
+	var latest struct {
+		CarbonIntensity *float64 `json:"carbonIntensity"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, electricityMapsURL+"?zone="+url.QueryEscape(*carbonZonePtr), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("auth-token", *carbonKeyPtr)
+	if err := carbonRequest(req, &latest); err != nil {
+		return 0, err
+	}
+	if latest.CarbonIntensity == nil {
+		return 0, fmt.Errorf("no carbon intensity for zone %s", *carbonZonePtr)
+	}
+	return *latest.CarbonIntensity, nil
+}
+
+func wattTimeIntensity(ctx context.Context) (float64, error) {
+	var login struct {
+		Token string `json:"token"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wattTimeURL+"/login", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.SetBasicAuth(*carbonUserPtr, *carbonKeyPtr)
+	if err := carbonRequest(req, &login); err != nil {
+		return 0, err
+	}
+
+	var forecast struct {
+		Data []struct {
+			Value float64 `json:"value"`
+		} `json:"data"`
+	}
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, wattTimeURL+"/v3/forecast?"+url.Values{
+		"region":        {*carbonZonePtr},
+		"signal_type":   {"co2_moer"},
+		"horizon_hours": {"0"},
+	}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+login.Token)
+	if err := carbonRequest(req, &forecast); err != nil {
+		return 0, err
+	}
+	if len(forecast.Data) == 0 {
+		return 0, fmt.Errorf("no carbon intensity for region %s", *carbonZonePtr)
+	}
+	// lbs/MWh to g/kWh
+	return forecast.Data[0].Value * gramsPerPound / 1000, nil
+}
+
+func carbonRequest(req *http.Request, result interface{}) error {
+	resp, err := carbonClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("%s %s: %w", req.Method, req.URL.Redacted(), err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Connection checks
+
+// The check command tries the Envoy and the outputs, logging in where they
+// need it, without collecting or writing anything, and reports whether each
+// is OK, failing with the exit code of the first problem (see exitCodes.go).
+// Most outputs can't be checked without writing to them, and are listed as
+// not checked.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Checks of outputs which can be checked without writing, returning a note on
+// anything that couldn't be
+var outputChecks = map[string]func() (string, error){
+	"influx": func() (string, error) {
+		if *influxUDPPtr != "" {
+			return "not checked over UDP", nil
+		}
+		return primaryInflux().ping()
+	},
+	"influx-secondary": func() (string, error) {
+		t, err := secondaryInflux()
+		if err != nil {
+			return "", err
+		}
+		return t.ping()
+	},
+}
+
+// ping checks an InfluxDB can be reached, and for 1.x that the user can see the
+// database
+func (t influxTarget) ping() (string, error) {
+	c, err := t.client()
+	if err != nil {
+		return "", err
+	}
+	if t.version >= 2 {
+		if _, _, err := c.Ping(*influxTimeoutPtr); err != nil {
+			return "", err
+		}
+		return "reachable, token not checked", nil
+	}
+	databases, err := queryValues(c, "SHOW DATABASES")
+	if err != nil {
+		return "", err
+	}
+	for _, row := range databases {
+		if len(row) > 0 && row[0] == t.database {
+			return "", nil
+		}
+	}
+	return "", configError("database %q doesn't exist, or %s can't see it", t.database, t.user)
+}
+
+type connectionCheck struct {
+	name  string
+	check func() (string, error)
+}
+
+// envoyCheck checks an Envoy API path can be read
+func envoyCheck(path string) func() (string, error) {
+	return func() (string, error) {
+		resp, err := getEnvoy(context.Background(), path)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		return "", nil
+	}
+}
+
+func checkConnections() error {
+	checks := []connectionCheck{
+		{"Envoy " + *envoyHostPtr, envoyCheck("/production.json?details=1")},
+	}
+	if *envoyTokenPtr != "" || *envoyPwPtr != "" || collecting("inverters") {
+		checks = append(checks, connectionCheck{"Envoy inverters", envoyCheck("/api/v1/production/inverters")})
+	}
+	paths := map[string]string{"meters": "/ivp/meters/readings", "inventory": "/inventory.json", "ensemble": "/ivp/ensemble/inventory", "events": "/datatab/event_dt.rb?start=0&length=1"}
+	for _, name := range sortedKeys(paths) {
+		if collecting(name) {
+			checks = append(checks, connectionCheck{"Envoy " + name, envoyCheck(paths[name])})
+		}
+	}
+	for _, o := range enabledOutputs {
+		checks = append(checks, connectionCheck{"Output " + o.name, outputChecks[o.name]})
+	}
+
+	failures := []string{}
+	code := 0
+	for _, c := range checks {
+		if c.check == nil {
+			fmt.Printf("%-30s not checked, as it can't be without writing\n", c.name)
+			continue
+		}
+		note, err := c.check()
+		switch {
+		case err != nil:
+			fmt.Printf("%-30s failed: %v\n", c.name, err)
+			failures = append(failures, c.name)
+			if code == 0 {
+				code = exitCode(err)
+			}
+		case note != "":
+			fmt.Printf("%-30s OK, %s\n", c.name, note)
+		default:
+			fmt.Printf("%-30s OK\n", c.name)
+		}
+	}
+	if len(failures) > 0 {
+		return exitError{code, errors.New("checking " + strings.Join(failures, ", ") + " failed")}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// ClickHouse output
+
+// Each measurement is written to a MergeTree table of the same name in the
+// -clickhouse-db database, over ClickHouse's HTTP interface (https:// for TLS).
+// Tables are created as e.g.
+//   CREATE TABLE readings (time DateTime, type LowCardinality(String),
+//     watts Nullable(Float64), ...) ENGINE = MergeTree ORDER BY (type, time)
+// with columns added as new tags or fields appear.  Rows are sent as async
+// inserts, which ClickHouse batches server side.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	clickHouseUrlPtr  = flag.String("clickhouse-url", "http://localhost:8123", "ClickHouse HTTP interface URL, https://host:8443 for TLS")
+	clickHouseDbPtr   = flag.String("clickhouse-db", "default", "ClickHouse database")
+	clickHouseUserPtr = flag.String("clickhouse-user", "default", "ClickHouse username")
+	clickHousePwPtr   = flag.String("clickhouse-pw", "", "ClickHouse password (or CLICKHOUSE_PASSWORD / CLICKHOUSE_PASSWORD_FILE)")
+)
+
+func writeClickHouse(ctx context.Context, pts []*client.Point) error {
+	tables := map[string][]*client.Point{}
+	for _, pt := range pts {
+		tables[pt.Name()] = append(tables[pt.Name()], pt)
+	}
+
+	for _, table := range sortedKeys(tables) {
+		tags := map[string]bool{}
+		columnTypes := map[string]string{}
+		var rows bytes.Buffer
+		enc := json.NewEncoder(&rows)
+		for _, pt := range tables[table] {
+			row, err := pt.Fields()
+			if err != nil {
+				return err
+			}
+			for k, v := range row {
+				if _, ok := columnTypes[k]; !ok {
+					columnTypes[k] = clickHouseType(v)
+				}
+			}
+			for k, v := range pt.Tags() {
+				tags[k] = true
+				columnTypes[k] = "LowCardinality(String)"
+				row[k] = v
+			}
+			row["time"] = pt.Time().Unix()
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+
+		name := clickHouseIdentifier(*clickHouseDbPtr) + "." + clickHouseIdentifier(table)
+		columns := []string{"time DateTime"}
+		orderBy := []string{}
+		for _, column := range sortedKeys(columnTypes) {
+			columns = append(columns, clickHouseIdentifier(column)+" "+columnTypes[column])
+			if tags[column] {
+				orderBy = append(orderBy, clickHouseIdentifier(column))
+			}
+		}
+		orderBy = append(orderBy, "time")
+		err := clickHouseQuery(ctx, nil, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = MergeTree ORDER BY (%s)",
+			name, strings.Join(columns, ", "), strings.Join(orderBy, ", ")))
+		if err != nil {
+			return err
+		}
+		for _, column := range columns[1:] {
+			if err := clickHouseQuery(ctx, nil, "ALTER TABLE "+name+" ADD COLUMN IF NOT EXISTS "+column); err != nil {
+				return err
+			}
+		}
+
+		params := url.Values{
+			"async_insert":          {"1"},
+			"wait_for_async_insert": {"1"},
+		}
+		if err := clickHouseQuery(ctx, params, "INSERT INTO "+name+" FORMAT JSONEachRow\n"+rows.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clickHouseQuery(ctx context.Context, params url.Values, query string) error {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(*clickHouseUrlPtr, "/")+"/?"+params.Encode(), strings.NewReader(query))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-ClickHouse-User", *clickHouseUserPtr)
+	req.Header.Set("X-ClickHouse-Key", *clickHousePwPtr)
+	_, err = doOutputRequest(ctx, req)
+	return err
+}
+
+func clickHouseIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "\\`", -1) + "`"
+}
+
+func clickHouseType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "Nullable(Float64)"
+	case int64:
+		return "Nullable(Int64)"
+	case bool:
+		return "Nullable(Bool)"
+	}
+	return "Nullable(String)"
+}
@@ -0,0 +1,90 @@
+// Choosing what to collect
+
+// -collect picks which readings are collected from the Envoy, so systems
+// without consumption CTs or batteries, or whose inverter API needs logging in
+// to, don't fail on what they don't have.  Phases adds each phase of the
+// production and consumption readings collected (see phases.go).  Inverters,
+// meters, inventory, ensemble and events are each read from an API of their
+// own (see inverters.go, meters.go, inventory.go, ensemble.go and
+// envoyEvents.go), along with the readings, or in daemon mode on their own
+// cycle with -interval-<name>, e.g. -interval-inventory 1h, which collects them
+// whether listed or not.
+
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+)
+
+var collectPtr = flag.String("collect", "production,consumption,storage", "Comma separated readings to collect from the Envoy: production, consumption, storage, phases (of production and consumption), inverters, meters, inventory, ensemble and events (each also collected with its -interval-<name>)")
+
+// What -collect can name
+var collectables = []string{"production", "consumption", "storage", "phases", "inverters", "meters", "inventory", "ensemble", "events"}
+
+// Collectors of what -collect names other than production.json's readings,
+// each from an API of its own
+var apiCollectors = []collector{
+	{"inverters", collectInverters},
+	{"meters", collectMeters},
+	{"inventory", collectInventory},
+	{"ensemble", collectEnsemble},
+	{"events", collectEvents},
+}
+
+// Each API collector's own interval in daemon mode, by name
+var collectorIntervals = map[string]*time.Duration{
+	"inverters": flag.Duration("interval-inverters", 0, "In daemon mode, collect per-inverter production on its own this often, e.g. 5m (default with the readings, if in -collect)"),
+	"meters":    flag.Duration("interval-meters", 0, "In daemon mode, collect per-meter readings on their own this often (default with the readings, if in -collect)"),
+	"inventory": flag.Duration("interval-inventory", 0, "In daemon mode, collect the device inventory on its own this often, e.g. 1h (default with the readings, if in -collect)"),
+	"ensemble":  flag.Duration("interval-ensemble", 0, "In daemon mode, collect Ensemble batteries on their own this often, e.g. 1m (default with the readings, if in -collect)"),
+	"events":    flag.Duration("interval-events", 0, "In daemon mode, collect the Envoy's event log on its own this often, e.g. 15m (default with the readings, if in -collect)"),
+}
+
+func setupCollect() error {
+	for _, what := range strings.Split(*collectPtr, ",") {
+		if !contains(collectables, strings.TrimSpace(what)) {
+			return configError("can't -collect %q, only %s", what, strings.Join(collectables, ", "))
+		}
+	}
+	return nil
+}
+
+// collecting is whether -collect names what, or it has its own interval
+func collecting(what string) bool {
+	for _, c := range strings.Split(*collectPtr, ",") {
+		if strings.TrimSpace(c) == what {
+			return true
+		}
+	}
+	return ownInterval(what) > 0
+}
+
+// ownInterval is a collector's -interval-<name>, or zero if it's collected
+// with the readings
+func ownInterval(name string) time.Duration {
+	if interval := collectorIntervals[name]; interval != nil {
+		return *interval
+	}
+	return 0
+}
+
+// collectingReadings is whether anything from production.json is collected
+func collectingReadings() bool {
+	return collecting("production") || collecting("consumption") || collecting("storage")
+}
+
+// collectors are what -collect names, readings first
+func collectors() []collector {
+	list := []collector{}
+	if collectingReadings() {
+		list = append(list, collector{"readings", collect})
+	}
+	for _, c := range apiCollectors {
+		if collecting(c.name) {
+			list = append(list, c)
+		}
+	}
+	return list
+}
@@ -0,0 +1,66 @@
+// Commands
+
+// Besides collecting, which it does by default (once, or as a daemon with
+// -interval or -schedule), the binary has commands for running it and finding
+// out what's wrong, e.g.
+// > influxEnvoyStats run -interval 30s
+// > influxEnvoyStats -config envoy.yaml check
+// Flags can come before or after the command.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Commands, and what they do, for usage
+var commands = []struct{ name, usage string }{
+	{"run", "Run as a daemon, collecting every -interval (1m unless given) or on -schedule"},
+	{"once", "Collect once and exit, even with -interval or -schedule"},
+	{"check", "Check the Envoy and outputs can be reached and logged in to, without collecting or writing"},
+	{"dump [path...|all]", "Print the Envoy's raw JSON responses for API paths (default /production.json?details=1), or save them in -dump-dir"},
+	{"discover", "List the Envoys on the network, found with mDNS or by probing -cidr"},
+	{"version", "Print the version, commit and build date"},
+	{"config validate", "Report problems with the configuration, without contacting anything"},
+	{"config init [file]", "Write a starter -config file, asking for settings and probing the Envoy for what it has"},
+	{"schema", "Print the measurements, tags and fields that would be written"},
+	{"migrate [days]", "Copy the readings written with schema 1 into schema 2, or just those of the last days (see README)"},
+	{"healthcheck", "Check a daemon's -health-file, for Docker's HEALTHCHECK"},
+	{"sqlite-latest", "Print the latest readings from the -sqlite-path database"},
+	{"service install|start|stop|remove", "Manage the Windows service"},
+}
+
+// The arguments which are flags, wherever they were given, e.g. to install a
+// service with
+var flagArgs []string
+
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] [command] [flags]\n\nCommands (default collect once, or as a daemon with -interval or -schedule):\n", os.Args[0])
+		for _, c := range commands {
+			fmt.Fprintf(out, "  %-36s %s\n", c.name, c.usage)
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+}
+
+// parseCommand picks the command and its arguments out of the arguments after
+// the flags, parsing any flags after the command too
+func parseCommand() (string, []string, error) {
+	args := os.Args[1:]
+	flagArgs = args[:len(args)-flag.NArg()]
+	command := flag.Arg(0)
+	if command == "" {
+		return "", nil, nil
+	}
+	rest := flag.Args()[1:]
+	if err := flag.CommandLine.Parse(rest); err != nil {
+		return "", nil, configError("%v", err)
+	}
+	flagArgs = append(flagArgs, rest[:len(rest)-flag.NArg()]...)
+	return command, flag.Args(), nil
+}
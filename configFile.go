@@ -0,0 +1,148 @@
+// Configuration file
+
+// Settings can be kept in a YAML or TOML file given with -config, rather than
+// as a long command line.  Its keys are flag names, which can be grouped into
+// sections by their first word, e.g.
+//
+//	envoy:
+//	  host: envoy.local      # -e
+//	  token: ${ENVOY_TOKEN}  # -envoy-token
+//	  timeout: 5s            # -envoy-timeout
+//	interval: 30s
+//	output: [influx, mqtt]
+//	openhab:
+//	  items:
+//	    production.watts: Envoy_Production
+//
+// Lists become comma separated values and maps comma separated key=value pairs.
+// ${NAME} in a value is replaced from the environment.  Flags on the command
+// line take precedence over secrets from the environment, which take
+// precedence over the file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var configPtr = flag.String("config", "", "YAML or TOML file to read settings from, by flag name (see README)")
+
+// Friendlier names in the configuration file for terse flags
+var configAliases = map[string]string{
+	"envoy-host":         "e",
+	"influx-url":         "dba",
+	"influx-db":          "dbn",
+	"influx-user":        "dbu",
+	"influx-pw":          "dbp",
+	"influx-measurement": "m",
+	"verbose":            "v",
+}
+
+// Flags set from the configuration file
+var configFileFlags = map[string]bool{}
+
+var configEnvRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// loadConfigFile sets flags not given on the command line from -config
+func loadConfigFile() error {
+	if *configPtr == "" {
+		return nil
+	}
+	settings, err := readConfigFile(*configPtr)
+	if err != nil {
+		return err
+	}
+	set := explicitFlags()
+	for _, name := range sortedKeys(settings) {
+		if !set[name] {
+			if err := flag.Set(name, settings[name]); err != nil {
+				return configError("%s: %s: %v", *configPtr, name, err)
+			}
+			configFileFlags[name] = true
+		}
+	}
+	return nil
+}
+
+// readConfigFile reads a configuration file into flag values by flag name
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, configError("%v", err)
+	}
+	config := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	case ".toml":
+		err = toml.Unmarshal(data, &config)
+	default:
+		err = fmt.Errorf("unknown format, name it .yaml, .yml or .toml")
+	}
+	if err != nil {
+		return nil, configError("%s: %v", path, err)
+	}
+
+	settings := map[string]string{}
+	if err := configSettings(path, "", "", config, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// configSettings flattens a section of a configuration file into flag values
+func configSettings(path, prefix, keyPrefix string, section map[string]interface{}, settings map[string]string) error {
+	for key, value := range section {
+		name, keyPath := key, key
+		if prefix != "" {
+			name, keyPath = prefix+"-"+key, keyPrefix+"."+key
+		}
+		if alias, ok := configAliases[name]; ok {
+			name = alias
+		}
+		if flag.Lookup(name) == nil {
+			if subsection, ok := value.(map[string]interface{}); ok {
+				if err := configSettings(path, name, keyPath, subsection, settings); err != nil {
+					return err
+				}
+				continue
+			}
+			return configError("%s: unknown setting %s", path, keyPath)
+		}
+		settings[name] = configValue(value)
+	}
+	return nil
+}
+
+// configValue formats a configuration file value as a flag value
+func configValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return configEnvRef.ReplaceAllStringFunc(value, func(ref string) string {
+			return os.Getenv(configEnvRef.FindStringSubmatch(ref)[1])
+		})
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, configValue(item))
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(value))
+		for _, k := range sortedKeys(value) {
+			pairs = append(pairs, k+"="+configValue(value[k]))
+		}
+		return strings.Join(pairs, ",")
+	}
+	return fmt.Sprint(value)
+}
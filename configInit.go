@@ -0,0 +1,213 @@
+// Generating a configuration file
+
+// config init writes a starter -config file for the Envoy, to stdout or a new
+// file, e.g.
+// > influxEnvoyStats -e 192.168.1.50 config init envoy.yaml
+// It asks for the main settings when run in a terminal, taking the flags given
+// as the answers' defaults, or otherwise just takes the flags.  The Envoy is
+// probed for its serial number and firmware, and whether it has consumption
+// CTs, batteries and more than one phase, to pick what's collected.  Secrets
+// aren't written to the file, but left to the environment.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// envoyFeatures is what an Envoy was found to have
+type envoyFeatures struct {
+	info        envoyInfo
+	checked     bool // Whether its readings could be read
+	consumption bool
+	storage     bool
+	phases      bool
+}
+
+func configInit(args []string) error {
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+		if _, err := os.Stat(path); err == nil {
+			return configError("%s already exists", path)
+		}
+	}
+	if err := secretsFromEnv(); err != nil {
+		return err
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	interactive := isTerminal(os.Stdin)
+	// The first error reading an answer, after which no more are asked for
+	var readErr error
+	// ask asks for a setting in a terminal, defaulting to value
+	ask := func(question, value string) string {
+		if !interactive {
+			return value
+		}
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, value)
+		answer, err := stdin.ReadString('\n')
+		if err != nil {
+			// Not a terminal after all, e.g. /dev/null
+			fmt.Fprintln(os.Stderr)
+			interactive = false
+			if err != io.EOF {
+				readErr = fmt.Errorf("reading the answer: %w", err)
+			}
+		}
+		if answer = strings.TrimSpace(answer); answer != "" {
+			return answer
+		}
+		return value
+	}
+
+	host := ask("Envoy address, or auto to find it with mDNS", *envoyHostPtr)
+	if readErr != nil {
+		return readErr
+	}
+	if host == "auto" {
+		if err := discoverEnvoy(); err != nil {
+			return err
+		}
+	} else if err := flag.Set("e", host); err != nil {
+		return configError("-e %q: %v", host, err)
+	}
+	features := probeFeatures()
+	if features.info.Serial != "" {
+		fmt.Fprintf(os.Stderr, "Found Envoy %s, firmware %s\n", features.info.Serial, features.info.Firmware)
+	}
+
+	interval := "1m"
+	if *intervalPtr > 0 {
+		interval = intervalPtr.String()
+	}
+	interval = ask("How often to collect", interval)
+	output := ask("Outputs, comma separated", *outputPtr)
+	influxURL, influxDB := *influxAddrPtr, *dbNamePtr
+	if strings.Contains(output, "influx") {
+		influxURL = ask("InfluxDB URL", influxURL)
+		influxDB = ask("InfluxDB database", influxDB)
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	var config strings.Builder
+	fmt.Fprintf(&config, "# Written by influxEnvoyStats config init")
+	if features.info.Serial != "" {
+		fmt.Fprintf(&config, " for Envoy %s, firmware %s", features.info.Serial, features.info.Firmware)
+	}
+	fmt.Fprintf(&config, "\n# Check it with: influxEnvoyStats -config <this file> config validate\n")
+	fmt.Fprintf(&config, "envoy:\n  host: %s\n", yamlString(*envoyHostPtr))
+	if firmwareMajor(features.info.Firmware) >= 7 || *envoyTokenPtr != "" {
+		fmt.Fprintf(&config, "  # Firmware 7+ needs a token, from entrez.enphaseenergy.com, given in ENVOY_TOKEN\n")
+	}
+	if features.checked {
+		fmt.Fprintf(&config, "collect: [%s]\n", strings.Join(features.collect(), ", "))
+	} else {
+		fmt.Fprintf(&config, "# The Envoy's readings couldn't be read, so leave out what it doesn't have\ncollect: [production, consumption, storage]\n")
+	}
+	fmt.Fprintf(&config, "interval: %s\n", yamlString(interval))
+	outputs := []string{}
+	for _, o := range strings.Split(output, ",") {
+		outputs = append(outputs, strings.TrimSpace(o))
+	}
+	fmt.Fprintf(&config, "output: [%s]\n", strings.Join(outputs, ", "))
+	if strings.Contains(output, "influx") {
+		fmt.Fprintf(&config, "influx:\n  url: %s\n  db: %s\n  user: %s\n  # The password is given in DB_PW\n",
+			yamlString(influxURL), yamlString(influxDB), yamlString(*dbUserPtr))
+	}
+
+	if path == "" {
+		fmt.Print(config.String())
+		return nil
+	}
+	if err := ioutil.WriteFile(path, []byte(config.String()), 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	return nil
+}
+
+// probeFeatures finds out what the Envoy has, as far as it can
+func probeFeatures() envoyFeatures {
+	var features envoyFeatures
+	if info, err := probeEnvoy(*envoyHostPtr); err == nil {
+		features.info = info
+	}
+	if err := features.probeReadings(); err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't read the Envoy's readings: %v\n", err)
+	}
+	return features
+}
+
+// probeReadings finds out which readings the Envoy has
+func (f *envoyFeatures) probeReadings() error {
+	resp, err := getEnvoy(context.Background(), "/production.json?details=1")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var readings struct {
+		Production  []struct{ Lines []json.RawMessage }
+		Consumption []struct{ ActiveCount int }
+		Storage     []struct{ ActiveCount int }
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&readings); err != nil {
+		return err
+	}
+	for _, p := range readings.Production {
+		f.phases = f.phases || len(p.Lines) > 1
+	}
+	for _, c := range readings.Consumption {
+		f.consumption = f.consumption || c.ActiveCount > 0
+	}
+	for _, s := range readings.Storage {
+		f.storage = f.storage || s.ActiveCount > 0
+	}
+	f.checked = true
+	return nil
+}
+
+// collect is what to collect from the Envoy
+func (f envoyFeatures) collect() []string {
+	collect := []string{"production"}
+	if f.consumption {
+		collect = append(collect, "consumption")
+	}
+	if f.storage {
+		collect = append(collect, "storage")
+	}
+	if f.phases {
+		collect = append(collect, "phases")
+	}
+	return collect
+}
+
+// firmwareMajor is the major version of Envoy firmware, e.g. 7 for D7.0.88
+func firmwareMajor(firmware string) int {
+	major, _ := strconv.Atoi(strings.SplitN(strings.TrimLeft(firmware, "DRdr"), ".", 2)[0])
+	return major
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// yamlString formats a string as a YAML scalar, quoted if it needs to be
+func yamlString(s string) string {
+	// A string always marshals
+	out, _ := yaml.Marshal(s)
+	return strings.TrimSpace(string(out))
+}
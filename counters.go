@@ -0,0 +1,47 @@
+// Lifetime counter resets
+
+// The Envoy's lifetime energy counters (wh_lifetime) go back to zero when
+// meters are reconfigured or firmware is updated, which breaks queries taking
+// differences.  When production or total consumption's counter decreases, its
+// point gets a counter_reset field, and with -monotonic-counters a
+// wh_lifetime_adjusted field carries on from where the counter was before.
+// Net consumption's counter legitimately decreases when exporting, so isn't
+// checked.  With -state-file, counters are followed across restarts.
+
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+var monotonicCountersPtr = flag.Bool("monotonic-counters", false, "Also write wh_lifetime_adjusted, which keeps increasing when the Envoy's lifetime counters are reset")
+
+// lifetimeCounter follows a reading's lifetime counter
+type lifetimeCounter struct {
+	Last   float64 `json:"last"`   // Last value read
+	Offset float64 `json:"offset"` // Total of the values before each reset
+}
+
+// lifetimeCounterFields adds wh_lifetime, and counter_reset and
+// wh_lifetime_adjusted as they apply, to a reading's fields
+func lifetimeCounterFields(measurementType string, fields map[string]interface{}, whLifetime float64) {
+	fields["wh_lifetime"] = whLifetime
+	if measurementType != "production" && measurementType != "total-consumption" {
+		return
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	counter, ok := state.Counters[measurementType]
+	if ok && whLifetime < counter.Last {
+		log.Printf(journalPriority(4, "%s lifetime counter reset from %.0f to %.0f Wh"), measurementType, counter.Last, whLifetime)
+		fields["counter_reset"] = true
+		counter.Offset += counter.Last
+	}
+	counter.Last = whLifetime
+	state.Counters[measurementType] = counter
+	if *monotonicCountersPtr {
+		fields["wh_lifetime_adjusted"] = counter.Offset + whLifetime
+	}
+}
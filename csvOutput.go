@@ -0,0 +1,180 @@
+// CSV file output
+
+// Appends each measurement's points to a daily file in -csv-dir, named e.g.
+// readings-2019-01-31.csv by the readings' local date.  The columns are time,
+// then the tags and then the fields, each in name order, as in the header
+// written when the file was created.  When points have a column the day's file
+// doesn't, e.g. after -include-fields or -exclude-fields is changed, they're
+// written to another file with a header of its own, readings-2019-01-31.2.csv
+// and so on, rather than dropping the values.  Fields only on the odd point,
+// such as counter_reset, don't start another file: they're only written to a
+// file with a column for them.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var csvDirPtr = flag.String("csv-dir", ".", "Directory for daily CSV files")
+
+// Fields only on the odd point, e.g. counter_reset when a lifetime counter is
+// reset, which don't start another file
+var csvOccasionalFields = []string{"counter_reset"}
+
+func writeCSV(ctx context.Context, pts []*client.Point) error {
+	files := map[string][]*client.Point{}
+	for _, pt := range pts {
+		name := pt.Name() + "-" + pt.Time().Local().Format("2006-01-02")
+		files[name] = append(files[name], pt)
+	}
+	for _, name := range sortedKeys(files) {
+		if err := appendCSV(filepath.Join(*csvDirPtr, name), files[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendCSV appends points to the last of a day's files, named from base, or
+// to a new one if they have columns it doesn't
+func appendCSV(base string, pts []*client.Point) error {
+	columns, err := csvColumns(pts)
+	if err != nil {
+		return err
+	}
+	n := 1
+	for {
+		if _, err := os.Stat(csvPath(base, n+1)); err != nil {
+			break
+		}
+		n++
+	}
+	path := csvPath(base, n)
+	header, err := csvHeader(path)
+	if err != nil {
+		return err
+	}
+	if missing := missingColumns(header, columns); header != nil && len(missing) > 0 {
+		next := csvPath(base, n+1)
+		log.Printf("%s has no columns for %s, writing to %s", path, strings.Join(missing, ", "), next)
+		path, header = next, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+
+	if header == nil {
+		header = columns
+		if err := w.Write(header); err != nil {
+			return err
+		}
+	}
+
+	for _, pt := range pts {
+		values, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		for k, v := range pt.Tags() {
+			values[k] = v
+		}
+		values["time"] = pt.Time().Local().Format(time.RFC3339)
+
+		record := make([]string, len(header))
+		for i, column := range header {
+			if v, ok := values[column]; ok {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// csvPath is the path of the nth of a day's files
+func csvPath(base string, n int) string {
+	if n == 1 {
+		return base + ".csv"
+	}
+	return fmt.Sprintf("%s.%d.csv", base, n)
+}
+
+// csvColumns are the columns for points: time, then the tags and then the
+// fields, each in name order
+func csvColumns(pts []*client.Point) ([]string, error) {
+	tags, fields := map[string]bool{}, map[string]bool{}
+	for _, pt := range pts {
+		for k := range pt.Tags() {
+			tags[k] = true
+		}
+		ptFields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		for k := range ptFields {
+			fields[k] = true
+		}
+	}
+	return append(append([]string{"time"}, sortedKeys(tags)...), sortedKeys(fields)...), nil
+}
+
+// missingColumns are the columns a header doesn't have, other than occasional
+// fields
+func missingColumns(header, columns []string) []string {
+	missing := []string{}
+	for _, column := range columns {
+		if !contains(header, column) && !csvOccasional(column) {
+			missing = append(missing, column)
+		}
+	}
+	return missing
+}
+
+// csvOccasional is whether a column is one of csvOccasionalFields, by the name
+// it's written with
+func csvOccasional(column string) bool {
+	renamed := mapping("rename-fields")
+	for _, field := range csvOccasionalFields {
+		if column == field {
+			return true
+		}
+		// Renamed, in any measurement or one
+		for from, to := range renamed {
+			if to == column && (from == field || strings.HasSuffix(from, "."+field)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// csvHeader returns the columns of an existing file
+func csvHeader(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return csv.NewReader(f).Read()
+}
@@ -0,0 +1,259 @@
+// Daemon mode
+
+// With -interval the Envoy is polled on a fixed interval, or with -schedule at
+// the times of a cron expression, until SIGINT/SIGTERM, instead of once per run
+// from cron, so it can run as a systemd or Docker service.  With the site's
+// location, -interval only applies in daylight (see sun.go).  The first
+// collection is delayed by a random part of -start-jitter, so that many
+// collectors restarted together don't poll in step, or with -align collections
+// are on round multiples of the interval, so samples line up across restarts
+// and sites.  Collectors with an -interval-<name> (see collectors.go) run on
+// their own cycle of that interval, one collection at a time.  A
+// failed collection is logged and the next one goes ahead as usual.  On
+// stopping, points outputs failed to write are retried once more before
+// exiting, with a non-zero status if any are still unwritten.  SIGHUP reloads
+// the configuration (see reload.go), systemd can supervise it (see systemd.go), Docker
+// can check its health (see health.go) and monitors can ask for its status
+// (see status.go).
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/robfig/cron/v3"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	intervalPtr    = flag.Duration("interval", 0, "Run as a daemon, collecting this often, e.g. 60s (default collect once and exit)")
+	schedulePtr    = flag.String("schedule", "", "Run as a daemon, collecting at the times of this cron expression (minute hour day month weekday), e.g. \"*/1 6-21 * * *\"")
+	startJitterPtr = flag.Duration("start-jitter", time.Second*5, "Most to randomly delay a daemon's first collection by")
+	alignPtr       = flag.Bool("align", false, "Collect on round multiples of -interval by the clock, e.g. at :00 and :30 with 30s, instead of -start-jitter")
+)
+
+func daemonMode() bool {
+	return *intervalPtr > 0 || *schedulePtr != ""
+}
+
+// Collections and writes are one at a time, as collectors can be due together
+var cycleMu sync.Mutex
+
+// collector collects one kind of points from the Envoy
+type collector struct {
+	name    string
+	collect func(context.Context) ([]*client.Point, error)
+}
+
+// collectOnce collects what -collect names, but in daemon mode those with
+// their own cycle, writing whatever was collected even if a collector failed
+func collectOnce(ctx context.Context) error {
+	all := collectors()
+	if len(all) == 0 {
+		return configError("nothing to collect, see -collect")
+	}
+	list := []collector{}
+	for _, c := range all {
+		if ownInterval(c.name) == 0 || !daemonMode() {
+			list = append(list, c)
+		}
+	}
+
+	pts := []*client.Point{}
+	failures := []error{}
+	for _, c := range list {
+		collected, err := c.collect(ctx)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("collecting %s: %w", c.name, err))
+			continue
+		}
+		pts = append(pts, collected...)
+	}
+	if len(pts) > 0 {
+		failures = append(failures, publish(ctx, busEvent{Kind: dataReadyEvent, Points: pts}))
+	}
+	// exitCode finds the first failure's code in these
+	return errors.Join(failures...)
+}
+
+// collectWith collects with one collector, on its own cycle
+func collectWith(c collector) func(context.Context) error {
+	return func(ctx context.Context) error {
+		pts, err := c.collect(ctx)
+		if err != nil {
+			return fmt.Errorf("collecting %s: %w", c.name, err)
+		}
+		return publish(ctx, busEvent{Kind: dataReadyEvent, Points: pts})
+	}
+}
+
+// runDaemon collects until stopped by a signal or parent being done, then
+// flushes any points outputs failed to take, failing if some still couldn't
+// be written
+func runDaemon(parent context.Context) error {
+	if err := setupSchedule(); err != nil {
+		return err
+	}
+	next := nextCollection
+
+	ctx, stop := signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reloadOnSIGHUP(ctx)
+	renewVault(ctx)
+	if err := serveStatus(); err != nil {
+		return err
+	}
+	log.Printf("influxEnvoyStats %s", versionString())
+	if last := lastCollected(); !last.IsZero() {
+		log.Printf("Last collected at %s, %s ago", last.Format(time.RFC3339), time.Since(last).Round(time.Second))
+	}
+
+	if *alignPtr && *schedulePtr == "" {
+		if !sleepUntil(ctx, next(time.Now())) {
+			return nil
+		}
+	} else if *startJitterPtr > 0 && !sleepUntil(ctx, time.Now().Add(time.Duration(rand.Int63n(int64(*startJitterPtr))))) {
+		return nil
+	}
+	var wg sync.WaitGroup
+	for _, c := range apiCollectors {
+		interval := ownInterval(c.name)
+		if interval == 0 {
+			continue
+		}
+		log.Printf("Collecting %s every %s", c.name, interval)
+		wg.Add(1)
+		go func(c collector) {
+			defer wg.Done()
+			runCollector(ctx, c.name, collectWith(c), func(t time.Time) time.Time {
+				return afterInterval(t, interval)
+			})
+		}(c)
+	}
+
+	startWatchdog()
+	sdNotify("READY=1")
+
+	if *schedulePtr != "" {
+		log.Printf("Collecting on schedule %q, next at %s", *schedulePtr, next(time.Now()).Format(time.RFC3339))
+		if sleepUntil(ctx, next(time.Now())) {
+			runCollector(ctx, "readings", collectOnce, next)
+		}
+	} else {
+		if solarAware() {
+			log.Printf("Collecting every %s in daylight and every %s at night", *intervalPtr, *nightIntervalPtr)
+		} else {
+			log.Printf("Collecting every %s", *intervalPtr)
+		}
+		runCollector(ctx, "readings", collectOnce, next)
+	}
+	wg.Wait()
+
+	// A second signal while flushing stops straight away
+	stop()
+	sdNotify("STOPPING=1")
+	log.Print("Stopping")
+	// Without a deadline, as ctx is done by now
+	if unwritten := flushOutputs(context.Background()); unwritten > 0 {
+		return exitError{exitWriteFailed, fmt.Errorf("stopped with %d points unwritten", unwritten)}
+	}
+	return nil
+}
+
+// The parsed -schedule, if any
+var collectSchedule cron.Schedule
+
+func setupSchedule() error {
+	collectSchedule = nil
+	if *schedulePtr == "" {
+		return nil
+	}
+	schedule, err := cron.ParseStandard(*schedulePtr)
+	if err != nil {
+		return configError("-schedule: %v", err)
+	}
+	collectSchedule = schedule
+	return nil
+}
+
+// nextCollection gives when to collect readings after a collection started at
+// t, by -schedule, or -interval (only in daylight with the site's location),
+// as they are now
+func nextCollection(t time.Time) time.Time {
+	switch {
+	case collectSchedule != nil:
+		return collectSchedule.Next(t)
+	case solarAware():
+		return solarNext(t)
+	}
+	return afterInterval(t, *intervalPtr)
+}
+
+// afterInterval gives when to collect every interval after a collection at t,
+// which with -align is the next round multiple of interval since the zero time
+// (so by UTC for intervals of a day or more)
+func afterInterval(t time.Time, interval time.Duration) time.Time {
+	if *alignPtr {
+		return t.Truncate(interval).Add(interval)
+	}
+	return t.Add(interval)
+}
+
+// runCollector runs a collector's cycles at the times given by next until
+// stopped, waiting longer while outputs fall behind (see backPressure.go)
+func runCollector(ctx context.Context, name string, collect func(context.Context) error, next func(time.Time) time.Time) {
+	stretch := 1
+	for {
+		start := time.Now()
+		runCycle(name, collect, stretched(start, next, stretch).Sub(start))
+		stretch = adaptStretch(name, stretch)
+		if !sleepUntil(ctx, stretched(start, next, stretch)) {
+			return
+		}
+	}
+}
+
+// sleepUntil waits until t, returning false if stopped first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// runCycle collects and writes once, logging rather than exiting on failure
+func runCycle(name string, collect func(context.Context) error, period time.Duration) {
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+	start := time.Now()
+	setCycleStarted(start)
+	err := withCycleDeadline(collect)
+	setCycleStarted(time.Time{})
+	recordHealth(name, start, err)
+	took := time.Since(start)
+	if err != nil {
+		log.Printf(journalPriority(3, "Collection failed after %s: %v"), took.Round(time.Millisecond), err)
+		sdNotify("STATUS=Collection at " + start.Format("15:04:05") + " failed: " + redactSecrets(err.Error()))
+	} else {
+		if *verbosePtr {
+			log.Printf("Collection took %s", took.Round(time.Millisecond))
+		}
+		sdNotify("STATUS=Last collected at " + start.Format("15:04:05"))
+	}
+	if took > period {
+		log.Printf(journalPriority(4, "Collection took %s, longer than the %s until the next"), took.Round(time.Millisecond), period.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,118 @@
+// Compact long-term storage of daily production curves
+
+// Each day's production is stored as a single point in the daily_curves
+// measurement, so the shape of the curve survives after the raw readings have
+// been downsampled away.  The "curve" field holds the most watts in each 5
+// minutes of the local day, 288 of them, or 276 or 300 on a day the clocks
+// change, each a big-endian uint16, gzipped and then base64 encoded.  The
+// maxima keep short peaks, as for clipping, which means would smooth over;
+// the day's energy is from the means.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"math"
+	"time"
+)
+
+const (
+	dailyCurveMeasurement = "daily_curves"
+	dailyCurveInterval    = 5 * time.Minute
+)
+
+func storeDailyCurve(date string) error {
+	day, err := parseDay(date)
+	if err != nil {
+		return err
+	}
+	c, err := newInfluxClient()
+	if err != nil {
+		return err
+	}
+	measurement, field, where := powerSeries("production")
+	end := day.AddDate(0, 0, 1)
+	cmd := fmt.Sprintf(`SELECT max(%q), mean(%q) FROM %q WHERE %s AND time >= '%s' AND time < '%s' GROUP BY time(%s) fill(0)`,
+		field, field, measurement, where, day.Format(time.RFC3339), end.Format(time.RFC3339), influxDuration(dailyCurveInterval))
+	values, err := queryValues(c, cmd)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		fmt.Printf("%s: no production readings\n", day.Format("2006-01-02"))
+		return nil
+	}
+
+	// As many samples as the local day has, 23 or 25 hours when the clocks change
+	curve := make([]uint16, end.Sub(day)/dailyCurveInterval)
+	peak, wh := 0.0, 0.0
+	for _, value := range values {
+		t, err := time.Parse(time.RFC3339Nano, value[0].(string))
+		if err != nil {
+			return fmt.Errorf("production reading time: %w", err)
+		}
+		i := int(t.Sub(day) / dailyCurveInterval)
+		if i < 0 || i >= len(curve) {
+			continue
+		}
+		watts := math.Min(math.Max(jsonFloat(value[1]), 0), math.MaxUint16)
+		curve[i] = uint16(math.Round(watts))
+		peak = math.Max(peak, watts)
+		wh += math.Max(jsonFloat(value[2]), 0) * dailyCurveInterval.Hours()
+	}
+
+	pt, encoded, err := dailyCurvePoint(day, curve, peak, wh)
+	if err != nil {
+		return err
+	}
+	if err := writePoints(context.Background(), []*client.Point{pt}); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %d samples, peak %.0f W, %.0f Wh, %d bytes\n", day.Format("2006-01-02"), len(curve), peak, wh, len(encoded))
+	return nil
+}
+
+// parseDay is the start of a local day given as YYYY-MM-DD or "yesterday"
+func parseDay(date string) (time.Time, error) {
+	if date == "yesterday" {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, now.Location()), nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return day, configError("invalid day %q, use YYYY-MM-DD or yesterday", date)
+	}
+	return day, nil
+}
+
+func dailyCurvePoint(day time.Time, curve []uint16, peak float64, wh float64) (*client.Point, string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	err := binary.Write(zw, binary.BigEndian, curve)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("compressing the curve: %w", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	tags := map[string]string{
+		"type": "production",
+	}
+	fields := map[string]interface{}{
+		"curve":      encoded,
+		"interval_s": int64(dailyCurveInterval.Seconds()),
+		"peak_watts": peak,
+		"wh":         wh,
+	}
+	pt, err := newPoint(dailyCurveMeasurement, tags, fields, day)
+	return pt, encoded, err
+}
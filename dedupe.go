@@ -0,0 +1,39 @@
+// Deduplication
+
+// Some Envoy readings only update every few minutes, so polling more often
+// collects the same points again.  A collected point is skipped if its series
+// has already been collected at the same or a later time, unless -force-write.
+// With -state-file, this carries over restarts (see state.go).
+
+package main
+
+import (
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+)
+
+var forceWritePtr = flag.Bool("force-write", false, "Write every point collected, even if its series was already collected at that time")
+
+// dedupePoints drops points which aren't newer than their series' last
+func dedupePoints(pts []*client.Point) []*client.Point {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	fresh := pts[:0:0]
+	for _, pt := range pts {
+		key := seriesKey(pt)
+		if last, ok := state.Series[key]; ok && !pt.Time().After(last) {
+			if !*forceWritePtr {
+				continue
+			}
+		} else {
+			state.Series[key] = pt.Time()
+		}
+		fresh = append(fresh, pt)
+	}
+	saveState()
+	if skipped := len(pts) - len(fresh); skipped > 0 && *verbosePtr {
+		log.Printf("Skipped %d points already collected", skipped)
+	}
+	return fresh
+}
@@ -0,0 +1,151 @@
+// Discovering Envoys
+
+// The discover command lists the Envoys on the network, with their serial
+// numbers and firmware, for setting up.  It asks with mDNS (see mdns.go), or
+// where that's blocked, e.g. across VLANs or from Docker's bridge network,
+// probes every address of -cidr for the Envoy's /info.xml, e.g.
+// > influxEnvoyStats -cidr 192.168.1.0/24 discover
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var cidrPtr = flag.String("cidr", "", "For the discover command, probe every address of this network for Envoys rather than asking with mDNS, e.g. 192.168.1.0/24")
+
+const (
+	// Addresses probed at once
+	discoverWorkers = 64
+	// Largest network to probe, a /16
+	discoverMaxHosts = 1 << 16
+)
+
+// envoyInfo is the Envoy's /info.xml, which needs no authentication
+type envoyInfo struct {
+	Serial   string `xml:"device>sn"`
+	Part     string `xml:"device>pn"`
+	Firmware string `xml:"device>software"`
+}
+
+func discoverCommand() error {
+	var found []discoveredEnvoy
+	var err error
+	if *cidrPtr != "" {
+		found, err = scanEnvoys(*cidrPtr)
+	} else {
+		found, err = browseEnvoys(mdnsWait)
+	}
+	if err != nil {
+		return err
+	}
+	if *cidrPtr == "" {
+		for i := range found {
+			if info, err := probeEnvoy(found[i].addr); err == nil {
+				found[i].firmware = info.Firmware
+			}
+		}
+	}
+	if len(found) == 0 {
+		return exitError{exitEnvoyUnreachable, errors.New("no Envoys found")}
+	}
+	fmt.Printf("%-22s %-14s %s\n", "ADDRESS", "SERIAL", "FIRMWARE")
+	for _, envoy := range found {
+		fmt.Printf("%-22s %-14s %s\n", envoy.addr, envoy.serial, envoy.firmware)
+	}
+	return nil
+}
+
+// scanEnvoys probes every address of a network for Envoys
+func scanEnvoys(cidr string) ([]discoveredEnvoy, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil || ip.To4() == nil {
+		return nil, configError("-cidr %q isn't an IPv4 network, e.g. 192.168.1.0/24", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if hosts := 1 << (bits - ones); hosts > discoverMaxHosts {
+		return nil, configError("-cidr %s has %d addresses, probe a /16 or smaller", cidr, hosts)
+	}
+
+	addrs := make(chan string)
+	go func() {
+		defer close(addrs)
+		first := network.IP.To4()
+		for ip := first; network.Contains(ip); ip = nextIP(ip) {
+			// Not the network or broadcast address
+			if ones < 31 && (ip.Equal(first) || !network.Contains(nextIP(ip))) {
+				continue
+			}
+			addrs <- ip.String()
+		}
+	}()
+
+	var mu sync.Mutex
+	found := map[string]discoveredEnvoy{}
+	var wg sync.WaitGroup
+	for i := 0; i < discoverWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range addrs {
+				if info, err := probeEnvoy(addr); err == nil && info.Serial != "" {
+					mu.Lock()
+					found[addr] = discoveredEnvoy{addr: addr, serial: info.Serial, firmware: info.Firmware}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	envoys := make([]discoveredEnvoy, 0, len(found))
+	for _, addr := range sortedKeys(found) {
+		envoys = append(envoys, found[addr])
+	}
+	return envoys, nil
+}
+
+// nextIP is the IPv4 address after ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+var discoverClient = &http.Client{
+	Timeout: 2 * time.Second,
+	Transport: &http.Transport{
+		// Envoys with firmware 7 redirect to HTTPS, with a self-signed certificate
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+}
+
+// probeEnvoy reads the /info.xml of what may be an Envoy
+func probeEnvoy(addr string) (envoyInfo, error) {
+	var info envoyInfo
+	resp, err := discoverClient.Get("http://" + addr + "/info.xml")
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return info, fmt.Errorf("%s", resp.Status)
+	}
+	err = xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info)
+	return info, err
+}
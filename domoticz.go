@@ -0,0 +1,77 @@
+// Domoticz output
+
+// Updates Domoticz virtual sensors through its JSON API: an Electric
+// (Instant+Counter) sensor each for production and consumption, and a
+// Percentage sensor for battery state of charge.  The electric sensors need
+// their Energy read setting on Computed, as the Envoy's energy counters reset
+// daily; Domoticz then integrates the power.  A sensor with no IDX isn't updated.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	domoticzURLPtr            = flag.String("domoticz-url", "http://localhost:8080", "Domoticz URL")
+	domoticzUserPtr           = flag.String("domoticz-user", "", "Domoticz username")
+	domoticzPwPtr             = flag.String("domoticz-pw", "", "Domoticz password (or DOMOTICZ_PASSWORD / DOMOTICZ_PASSWORD_FILE)")
+	domoticzProductionIdxPtr  = flag.Int("domoticz-production-idx", 0, "IDX of the Domoticz electric sensor for production")
+	domoticzConsumptionIdxPtr = flag.Int("domoticz-consumption-idx", 0, "IDX of the Domoticz electric sensor for total consumption")
+	domoticzSOCIdxPtr         = flag.Int("domoticz-soc-idx", 0, "IDX of the Domoticz percentage sensor for battery state of charge")
+)
+
+func writeDomoticz(ctx context.Context, pts []*client.Point) error {
+	readings := readingsByType(pts)
+	if watts, ok := readingValue(readings, "production.watts"); ok {
+		// Standby draw at night would count as negative production
+		if err := domoticzUpdate(ctx, *domoticzProductionIdxPtr, fmt.Sprintf("%.1f;0", math.Max(watts, 0))); err != nil {
+			return err
+		}
+	}
+	if watts, ok := readingValue(readings, "total-consumption.watts"); ok {
+		if err := domoticzUpdate(ctx, *domoticzConsumptionIdxPtr, fmt.Sprintf("%.1f;0", watts)); err != nil {
+			return err
+		}
+	}
+	if percent, ok := readingValue(readings, "storage.percent_full"); ok {
+		return domoticzUpdate(ctx, *domoticzSOCIdxPtr, fmt.Sprintf("%.0f", percent))
+	}
+	return nil
+}
+
+func domoticzUpdate(ctx context.Context, idx int, svalue string) error {
+	if idx == 0 {
+		return nil
+	}
+	params := url.Values{
+		"type":   {"command"},
+		"param":  {"udevice"},
+		"idx":    {fmt.Sprint(idx)},
+		"nvalue": {"0"},
+		"svalue": {svalue},
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(*domoticzURLPtr, "/")+"/json.htm?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if *domoticzUserPtr != "" {
+		req.SetBasicAuth(*domoticzUserPtr, *domoticzPwPtr)
+	}
+	body, err := doOutputRequest(ctx, req)
+	if err != nil {
+		return err
+	}
+	// Domoticz reports errors in the body with a 200 status
+	if !strings.Contains(string(body), `"OK"`) {
+		return fmt.Errorf("Domoticz update of idx %d: %s", idx, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
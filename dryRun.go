@@ -0,0 +1,27 @@
+// Dry runs
+
+// With -dry-run, readings are collected as usual, but the points that would be
+// written to each output, and to InfluxDB for events and reports, are printed
+// as line protocol, each with its time, instead of being written.  The state
+// file isn't updated, so the next real run still writes them.  Outputs which
+// map readings to their own names, e.g. openHAB items, do that when writing,
+// so aren't shown.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"strings"
+	"time"
+)
+
+var dryRunPtr = flag.Bool("dry-run", false, "Collect, but print the points that would be written to each output rather than writing them")
+
+func printDryRun(to []string, pts []*client.Point) {
+	fmt.Printf("Would write %d points to %s:\n", len(pts), strings.Join(to, ", "))
+	for _, pt := range pts {
+		fmt.Printf("  %s  # %s\n", pt.PrecisionString(*precisionPtr), pt.Time().UTC().Format(time.RFC3339))
+	}
+}
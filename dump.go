@@ -0,0 +1,113 @@
+// Dumping Envoy responses
+
+// The dump command prints the Envoy's raw JSON responses for API paths,
+// indented, for seeing what a firmware version returns, e.g. to attach to a bug
+// report, or with "all" for every endpoint the collectors and other tools use.
+// Endpoints an Envoy doesn't have, or needs other authentication for, are
+// reported and skipped.  With -dump-dir the responses are saved there instead,
+// a file for each.
+// > influxEnvoyStats -envoy-token ... dump /ivp/meters
+// > influxEnvoyStats -envoy-token ... -dump-dir envoy-dump dump all
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var dumpDirPtr = flag.String("dump-dir", "", "Directory for the dump command to save responses in, rather than printing them")
+
+// Envoy endpoints dumped for "all"
+var dumpEndpoints = []string{
+	"/info.xml",
+	"/production.json?details=1",
+	"/api/v1/production",
+	"/api/v1/production/inverters",
+	"/inventory.json",
+	"/home.json",
+	"/ivp/meters",
+	"/ivp/meters/readings",
+	"/ivp/livedata/status",
+	"/ivp/ensemble/inventory",
+	"/ivp/ensemble/status",
+}
+
+func dumpEnvoy(args []string) error {
+	paths := args
+	if len(paths) == 0 {
+		paths = []string{"/production.json?details=1"}
+	} else if len(paths) == 1 && paths[0] == "all" {
+		paths = dumpEndpoints
+	}
+	if *dumpDirPtr != "" {
+		if err := os.MkdirAll(*dumpDirPtr, 0755); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, path := range paths {
+		if err := dumpPath(path, len(paths) > 1); err != nil {
+			if len(paths) == 1 {
+				return err
+			}
+			log.Printf("Dumping %s: %v", path, err)
+			failed++
+		}
+	}
+	if failed == len(paths) {
+		return fmt.Errorf("no responses from the Envoy")
+	}
+	return nil
+}
+
+// dumpPath prints the Envoy's response for a path, headed with the path if
+// there are several, or saves it
+func dumpPath(path string, header bool) error {
+	resp, err := getEnvoy(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var indented bytes.Buffer
+	if json.Indent(&indented, body, "", "  ") == nil {
+		body = append(indented.Bytes(), '\n')
+	}
+	if *dumpDirPtr == "" {
+		if header {
+			fmt.Printf("# %s\n", path)
+		}
+		_, err := os.Stdout.Write(body)
+		return err
+	}
+	file := filepath.Join(*dumpDirPtr, dumpFileName(path))
+	if err := ioutil.WriteFile(file, body, 0644); err != nil {
+		return err
+	}
+	log.Printf("Saved %s to %s", path, file)
+	return nil
+}
+
+// dumpFileName is the file to save a path's response in, e.g.
+// ivp_meters_readings.json for /ivp/meters/readings
+func dumpFileName(path string) string {
+	name := strings.NewReplacer("/", "_", "?", "_", "&", "_", "=", "-").Replace(strings.Trim(path, "/"))
+	if !strings.HasSuffix(name, ".json") && !strings.HasSuffix(name, ".xml") {
+		name += ".json"
+	}
+	return name
+}
@@ -0,0 +1,67 @@
+// EmonCMS output
+
+// Posts readings to an EmonCMS input node with the bulk input API, one input per
+// reading type and field, e.g. production_watts and total-consumption_wh_today.
+// Inputs then need logging to feeds in EmonCMS as usual.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var (
+	emonCMSURLPtr  = flag.String("emoncms-url", "https://emoncms.org", "EmonCMS URL")
+	emonCMSNodePtr = flag.String("emoncms-node", "envoy", "EmonCMS input node")
+	emonCMSKeyPtr  = flag.String("emoncms-key", "", "EmonCMS read & write API key (or EMONCMS_KEY / EMONCMS_KEY_FILE)")
+)
+
+func writeEmonCMS(ctx context.Context, pts []*client.Point) error {
+	// Each update is [time, node, {input: value, ...}]
+	times := map[int64]map[string]float64{}
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		prefix := pt.Name()
+		if pt.Name() == *measurementNamePtr {
+			prefix = pt.Tags()["type"]
+		}
+		t := pt.Time().Unix()
+		if times[t] == nil {
+			times[t] = map[string]float64{}
+		}
+		for k, v := range fields {
+			if value, ok := numericValue(v); ok {
+				times[t][prefix+"_"+k] = value
+			}
+		}
+	}
+	data := [][]interface{}{}
+	for t, inputs := range times {
+		data = append(data, []interface{}{t, *emonCMSNodePtr, inputs})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i][0].(int64) < data[j][0].(int64) })
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(*emonCMSURLPtr, "/")+"/input/bulk",
+		strings.NewReader(url.Values{"data": {string(body)}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+*emonCMSKeyPtr)
+	_, err = doOutputRequest(ctx, req)
+	return err
+}
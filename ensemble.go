@@ -0,0 +1,75 @@
+// Ensemble batteries
+
+// With ensemble in -collect, each IQ Battery (Encharge) of an Ensemble system
+// is read from the Envoy's /ivp/ensemble/inventory (firmware 7+, with
+// -envoy-token) and written to the encharge measurement, tagged with its
+// serial number, e.g.
+//   encharge,serial=122000000001 percent_full=87,temperature=29,max_cell_temperature=31,capacity_wh=3360,communicating=true
+// These are the charge and temperatures of each battery, where storage
+// readings are the batteries' total.  Ensemble's other devices, such as the
+// Enpower switch, are left out.
+
+package main
+
+import (
+	"context"
+	"github.com/influxdata/influxdb/client/v2"
+	"time"
+)
+
+const enchargeMeasurement = "encharge"
+
+// enchargeDevice is a battery from /ivp/ensemble/inventory
+type enchargeDevice struct {
+	SerialNum        string  `json:"serial_num"`
+	LastRptDate      int64   `json:"last_rpt_date"`
+	PercentFull      float64 `json:"percentFull"`
+	Temperature      float64 `json:"temperature"`
+	MaxCellTemp      float64 `json:"maxCellTemp"`
+	EnchargeCapacity float64 `json:"encharge_capacity"`
+	Communicating    bool    `json:"communicating"`
+	Operating        bool    `json:"operating"`
+}
+
+func collectEnsemble(ctx context.Context) ([]*client.Point, error) {
+	inventory := []struct {
+		Type    string
+		Devices []enchargeDevice
+	}{}
+	if err := getEnvoyJSON(ctx, "/ivp/ensemble/inventory", &inventory); err != nil {
+		return nil, err
+	}
+	pts := []*client.Point{}
+	for _, kind := range inventory {
+		if kind.Type != "ENCHARGE" {
+			continue
+		}
+		for _, device := range kind.Devices {
+			pt, err := enchargePoint(device)
+			if err != nil {
+				return nil, err
+			}
+			pts = append(pts, pt)
+		}
+	}
+	return pts, nil
+}
+
+func enchargePoint(device enchargeDevice) (*client.Point, error) {
+	tags := map[string]string{
+		"serial": device.SerialNum,
+	}
+	fields := map[string]interface{}{
+		"percent_full":         device.PercentFull,
+		"temperature":          device.Temperature,
+		"max_cell_temperature": device.MaxCellTemp,
+		"capacity_wh":          device.EnchargeCapacity,
+		"communicating":        device.Communicating,
+		"operating":            device.Operating,
+	}
+	reported := time.Now()
+	if device.LastRptDate > 0 {
+		reported = time.Unix(device.LastRptDate, 0)
+	}
+	return newPoint(enchargeMeasurement, tags, fields, reported)
+}
@@ -0,0 +1,68 @@
+// Settings from the environment
+
+// Every setting can also be given as an environment variable, for containers
+// and the like, named after its configuration file key (see configFile.go)
+// with -env-prefix, e.g. ENVOYMON_ENVOY_HOST for -e or ENVOYMON_INTERVAL for
+// -interval, or as the path of a file holding it in NAME_FILE.  The
+// environment takes precedence over the configuration file, and the command
+// line over both.  Secrets are still read from their original variables too,
+// e.g. DB_PW, if the prefixed ones aren't set (see secrets.go).
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+var envPrefixPtr = flag.String("env-prefix", "ENVOYMON_", "Prefix of the environment variables settings can be given in, e.g. ENVOYMON_INTERVAL for -interval (see README)")
+
+// Flags set from the environment, with the variable they came from
+var envFlags = map[string]string{}
+
+// settingsFromEnv sets flags not given on the command line from the
+// environment, but for secrets, which are read with the others by
+// secretsFromEnv
+func settingsFromEnv() error {
+	set := explicitFlags()
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "env-prefix" || secretEnvVars[f.Name] != "" {
+			return
+		}
+		value, envVar, ok, readErr := settingFromEnv(f.Name)
+		if readErr != nil {
+			err = configError("%s: %v", envVar, readErr)
+		} else if ok {
+			if setErr := flag.Set(f.Name, value); setErr != nil {
+				err = configError("%s %q: %v", envVar, value, setErr)
+			}
+			envFlags[f.Name] = envVar
+		}
+	})
+	return err
+}
+
+// settingFromEnv reads a flag's value from its environment variable, or the
+// file named by its _FILE variable, with the variable it came from
+func settingFromEnv(name string) (string, string, bool, error) {
+	envVar := envVarName(name)
+	if _, ok := os.LookupEnv(envVar + "_FILE"); ok {
+		value, _, err := secretFromEnv(envVar)
+		return value, envVar + "_FILE", true, err
+	}
+	value, ok := os.LookupEnv(envVar)
+	return value, envVar, ok, nil
+}
+
+// envVarName is a flag's environment variable, e.g. ENVOYMON_ENVOY_HOST for -e
+func envVarName(name string) string {
+	for key, alias := range configAliases {
+		if alias == name {
+			name = key
+			break
+		}
+	}
+	return *envPrefixPtr + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
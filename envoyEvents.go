@@ -0,0 +1,109 @@
+// Envoy events
+
+// With events in -collect, the Envoy's event log, such as a microinverter
+// failing to report or the grid going out of range, is read from the table its
+// own web pages show, /datatab/event_dt.rb, and published as envoy events (see
+// bus.go), which are written to the events measurement like alerts, tagged
+// type=envoy with the device the event is about as the text, e.g.
+//   events,type=envoy title="Microinverter failed to report",text="121935144671 (PCU)"
+// so they can be shown as annotations too.  The log only has times to the
+// minute, so events in the same minute are written a second apart to keep
+// them all.  The id of the last event written is kept with the state (see
+// state.go), so each is only written once, unless the log starts again from
+// lower ids, e.g. after the Envoy is reset.  Firmware which doesn't serve the
+// table fails the collector.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many of the latest events are read each collection
+const envoyEventsRead = 100
+
+// How the event log shows times, in the Envoy's time zone
+const envoyEventTime = "Mon Jan 02, 2006 03:04 PM MST"
+
+// envoyEvent is a row of the event log
+type envoyEvent struct {
+	id     int64
+	title  string
+	device string
+	time   time.Time
+}
+
+// collectEvents publishes the latest events in the log, which are written
+// rather than returned as points
+func collectEvents(ctx context.Context) ([]*client.Point, error) {
+	table := struct {
+		AaData [][]string `json:"aaData"`
+	}{}
+	path := fmt.Sprintf("/datatab/event_dt.rb?start=0&length=%d", envoyEventsRead)
+	if err := getEnvoyJSON(ctx, path, &table); err != nil {
+		return nil, err
+	}
+	events := []envoyEvent{}
+	for _, row := range table.AaData {
+		e, err := parseEnvoyEvent(row)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	// Oldest first, as the log is newest first
+	sort.Slice(events, func(i, j int) bool { return events[i].id < events[j].id })
+
+	stateMu.Lock()
+	written := state.EnvoyLog
+	stateMu.Unlock()
+	if len(events) > 0 && events[len(events)-1].id < written {
+		written = 0
+	}
+	last := time.Time{}
+	for _, e := range events {
+		if !e.time.After(last) {
+			e.time = last.Add(time.Second)
+		}
+		last = e.time
+		if e.id <= written {
+			continue
+		}
+		if err := publish(ctx, busEvent{Kind: envoyLogEvent, Time: e.time, Type: "envoy", Title: e.title, Text: e.device}); err != nil {
+			return nil, err
+		}
+		markEnvoyLog(e.id)
+	}
+	return nil, nil
+}
+
+// markEnvoyLog notes the id of the last event from the log written
+func markEnvoyLog(id int64) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	state.EnvoyLog = id
+	saveState()
+}
+
+// parseEnvoyEvent parses a row of the event log: its id, what happened, the
+// device it happened to and when
+func parseEnvoyEvent(row []string) (envoyEvent, error) {
+	if len(row) < 4 {
+		return envoyEvent{}, fmt.Errorf("event log row %q has %d columns, not 4", row, len(row))
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(row[0]), 10, 64)
+	if err != nil {
+		return envoyEvent{}, fmt.Errorf("event log id %q: %w", row[0], err)
+	}
+	t, err := time.ParseInLocation(envoyEventTime, strings.TrimSpace(row[3]), time.Local)
+	if err != nil {
+		return envoyEvent{}, fmt.Errorf("event %d: %w", id, err)
+	}
+	return envoyEvent{id: id, title: strings.TrimSpace(row[1]), device: strings.TrimSpace(row[2]), time: t}, nil
+}
@@ -0,0 +1,91 @@
+// Envoy request limits
+
+// The Envoy's web server is weak and drops connections under load, so requests
+// to it, from every collector, are limited to -envoy-rate a second and at most
+// -envoy-max-inflight at once.  A request stays in flight until its response
+// body is closed, and times out after -envoy-timeout from when it's made
+// rather than from when it was asked for.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"golang.org/x/time/rate"
+	"io"
+	"net/http"
+	"sync"
+)
+
+var (
+	envoyRatePtr        = flag.Float64("envoy-rate", 0, "Most requests a second to make to the Envoy, e.g. 0.5 (0 for no limit)")
+	envoyMaxInflightPtr = flag.Int("envoy-max-inflight", 1, "Most requests to have in flight to the Envoy at once")
+)
+
+var (
+	envoyLimiter     *rate.Limiter
+	envoyInflight    chan struct{}
+	envoyLimitsSetup sync.Once
+)
+
+// envoyLimitTransport holds requests back to within the Envoy's limits
+type envoyLimitTransport struct {
+	base http.RoundTripper
+}
+
+func (t *envoyLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	envoyLimitsSetup.Do(func() {
+		if *envoyRatePtr > 0 {
+			envoyLimiter = rate.NewLimiter(rate.Limit(*envoyRatePtr), 1)
+		}
+		if *envoyMaxInflightPtr > 0 {
+			envoyInflight = make(chan struct{}, *envoyMaxInflightPtr)
+		}
+	})
+
+	if envoyInflight != nil {
+		select {
+		case envoyInflight <- struct{}{}:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	release := func() {
+		if envoyInflight != nil {
+			<-envoyInflight
+		}
+	}
+	if envoyLimiter != nil {
+		if err := envoyLimiter.Wait(req.Context()); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), *envoyTimeoutPtr)
+	releaseSlot := release
+	release = func() {
+		cancel()
+		releaseSlot()
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody releases a request's in flight slot when it's closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
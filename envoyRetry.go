@@ -0,0 +1,64 @@
+// Envoy request retries
+
+// Envoys often fail requests with a 5xx or time out under load, so failed
+// requests are retried -envoy-retries times, waiting -envoy-retry-wait
+// (doubling) in between, before a collection gives up.  Requests which still
+// fail count towards the Envoy's circuit breaker (see breaker.go).
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	envoyRetriesPtr   = flag.Int("envoy-retries", 2, "How many times to retry an Envoy request which timed out or failed with a 5xx status")
+	envoyRetryWaitPtr = flag.Duration("envoy-retry-wait", time.Millisecond*500, "Wait before retrying a failed Envoy request, doubling each retry")
+)
+
+// envoyRetryTransport retries failed requests, which are all GETs so can
+// simply be resent
+type envoyRetryTransport struct {
+	base http.RoundTripper
+}
+
+func (t *envoyRetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := envoyBreaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.retry(req)
+	if err == nil && resp.StatusCode >= 500 {
+		envoyBreaker.record(fmt.Errorf("%s", resp.Status))
+	} else {
+		envoyBreaker.record(err)
+	}
+	return resp, err
+}
+
+func (t *envoyRetryTransport) retry(req *http.Request) (*http.Response, error) {
+	wait := *envoyRetryWaitPtr
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if err == nil && resp.StatusCode < 500 || attempt == *envoyRetriesPtr || req.Context().Err() != nil {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("%s", resp.Status)
+		}
+		log.Printf("Envoy %s: %v, retrying in %s", req.URL.Path, err, wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		wait *= 2
+	}
+}
@@ -0,0 +1,86 @@
+// Exec output
+
+// Runs -exec-command through the shell each run and writes the readings to its
+// stdin, as JSON lines (the same objects as the jsonl output) or line protocol,
+// e.g. -exec-command 'curl -s --data-binary @- http://example/ingest'.
+// A command that fails or takes over -exec-timeout is an error.  Events (see
+// bus.go) are piped to it too as they happen, each on its own run, as a JSON
+// object of its time, kind, type, title and text, or an events point in line
+// protocol.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+var (
+	execCommandPtr = flag.String("exec-command", "", "Command to pipe readings to each run")
+	execFormatPtr  = flag.String("exec-format", "json", "Format of readings piped to -exec-command: json or lineprotocol")
+	execTimeoutPtr = flag.Duration("exec-timeout", time.Second*30, "Time limit for -exec-command")
+)
+
+func writeExec(ctx context.Context, pts []*client.Point) error {
+	var stdin bytes.Buffer
+	switch *execFormatPtr {
+	case "json":
+		if err := encodeJSONLines(&stdin, pts); err != nil {
+			return err
+		}
+	case "lineprotocol":
+		if err := writeLines(&stdin, pts); err != nil {
+			return err
+		}
+	default:
+		return configError("unknown -exec-format %q", *execFormatPtr)
+	}
+	return runExec(ctx, &stdin)
+}
+
+func execEvent(ctx context.Context, e busEvent) error {
+	var stdin bytes.Buffer
+	switch *execFormatPtr {
+	case "json":
+		if err := json.NewEncoder(&stdin).Encode(newEventJSON(e)); err != nil {
+			return err
+		}
+	case "lineprotocol":
+		pt, err := eventPoint(e)
+		if err != nil {
+			return err
+		}
+		if err := writeLines(&stdin, []*client.Point{pt}); err != nil {
+			return err
+		}
+	default:
+		return configError("unknown -exec-format %q", *execFormatPtr)
+	}
+	return runExec(ctx, &stdin)
+}
+
+// runExec runs -exec-command with stdin
+func runExec(ctx context.Context, stdin *bytes.Buffer) error {
+	ctx, cancel := context.WithTimeout(ctx, *execTimeoutPtr)
+	defer cancel()
+	shell, shellFlag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, shellFlag = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(ctx, shell, shellFlag, *execCommandPtr)
+	cmd.Stdin = stdin
+	cmd.Stdout = console
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", *execCommandPtr, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Exit codes
+
+// A run which fails exits with a status saying why, so scripts and supervisors
+// can react, e.g. only alerting someone about a wrong password:
+//   1  any other failure
+//   2  invalid configuration
+//   3  the Envoy couldn't be reached
+//   4  the Envoy refused the credentials
+//   5  writing to an output failed
+// Failures are returned as errors, wrapped with what was being done, up to
+// main, which logs the error and exits with the code of the exitError it
+// wraps, if any.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	exitFailure          = 1
+	exitConfig           = 2
+	exitEnvoyUnreachable = 3
+	exitEnvoyAuth        = 4
+	exitWriteFailed      = 5
+)
+
+// exitError is an error which should exit with a particular status
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+// configError is an error in the options given
+func configError(format string, a ...interface{}) error {
+	return exitError{exitConfig, fmt.Errorf(format, a...)}
+}
+
+// exitCode is the status to exit with after err
+func exitCode(err error) int {
+	var e exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
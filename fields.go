@@ -0,0 +1,91 @@
+// Field selection
+
+// Not every field is worth storing everywhere.  -exclude-fields drops fields
+// from what's written, and -include-fields adds optional fields which the
+// Envoy reports but aren't written unless asked for, e.g. wh_last_seven_days,
+// or with -schema 2.
+// Either takes field names, for every measurement, or measurement.field for
+// one, e.g.
+//	-exclude-fields readings.wh_today,collected_at -include-fields wh_last_seven_days
+// Fields are picked by their original names, before any renaming, and points
+// left with no fields aren't written.
+
+package main
+
+import (
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"strings"
+)
+
+var (
+	includeFieldsPtr = flag.String("include-fields", "", "Comma separated optional fields to write too, or measurement.field for one measurement, e.g. wh_last_seven_days (see README)")
+	excludeFieldsPtr = flag.String("exclude-fields", "", "Comma separated fields not to write, or measurement.field for one measurement, e.g. readings.wh_today")
+)
+
+// Fields collected but only written with -include-fields
+var optionalFields = map[string]bool{
+	"wh_last_seven_days": true,
+	// Grid quality
+	"volts":        true,
+	"amps":         true,
+	"power_factor": true,
+	"vars":         true,
+	"va":           true,
+	// Apparent and reactive energy
+	"vah_today":          true,
+	"vah_lifetime":       true,
+	"varh_lead_today":    true,
+	"varh_lag_today":     true,
+	"varh_lead_lifetime": true,
+	"varh_lag_lifetime":  true,
+}
+
+// fieldList reads a comma separated list of fields into a set
+func fieldList(list string) map[string]bool {
+	fields := map[string]bool{}
+	for _, field := range strings.Split(list, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields[field] = true
+		}
+	}
+	return fields
+}
+
+// selectFields drops the fields of points which aren't to be written
+func selectFields(pts []*client.Point) ([]*client.Point, error) {
+	include := fieldList(*includeFieldsPtr)
+	exclude := fieldList(*excludeFieldsPtr)
+
+	selected := make([]*client.Point, 0, len(pts))
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		dropped := false
+		for k := range fields {
+			if fieldListed(exclude, pt.Name(), k) || optionalFields[k] && *schemaPtr < 2 && !fieldListed(include, pt.Name(), k) {
+				delete(fields, k)
+				dropped = true
+			}
+		}
+		if !dropped {
+			selected = append(selected, pt)
+			continue
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if pt, err = newPoint(pt.Name(), pt.Tags(), fields, pt.Time()); err != nil {
+			return nil, err
+		}
+		selected = append(selected, pt)
+	}
+	return selected, nil
+}
+
+// fieldListed is whether a field is in a list, for its measurement or any
+func fieldListed(fields map[string]bool, measurement, field string) bool {
+	return fields[field] || fields[measurement+"."+field]
+}
@@ -0,0 +1,116 @@
+// Grid import and export
+
+// Net consumption is positive when importing from the grid and negative when
+// exporting, which is awkward to query.  Its readings also get
+// grid_import_watts and grid_export_watts, one of which is zero, and
+// grid_import_wh_today and grid_export_wh_today, integrated from them since
+// local midnight.  The energy is only counted between readings at most
+// gridMaxGap apart, and carries on across restarts and cron runs with
+// -state-file.  With tariffs, what the energy cost and earned is counted with
+// it (see tariff.go).  When the grid goes from importing to exporting or back,
+// by more than gridDeadband, a grid state event is published (see bus.go), so
+// hooks can e.g. switch loads on while there's power to spare.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Longest time between readings to count the energy in between
+const gridMaxGap = 30 * time.Minute
+
+// Watts either way the grid has to go past to change state, so that hovering
+// around zero doesn't
+const gridDeadband = 50.0
+
+// gridEnergy follows the energy imported and exported today
+type gridEnergy struct {
+	Day          string    `json:"day"`           // Local date counted, e.g. 2024-05-01
+	Last         time.Time `json:"last"`          // Time of the last reading
+	ImportWatts  float64   `json:"import_watts"`  // Power imported at the last reading
+	ExportWatts  float64   `json:"export_watts"`  // Power exported at the last reading
+	ImportWh     float64   `json:"import_wh"`     // Energy imported today
+	ExportWh     float64   `json:"export_wh"`     // Energy exported today
+	ImportCost   float64   `json:"import_cost"`   // Cost of the energy imported today
+	ExportCredit float64   `json:"export_credit"` // Credit for the energy exported today
+	State        string    `json:"state"`         // importing or exporting
+}
+
+// gridFields adds grid import and export power, and energy today, and with
+// tariffs their cost, to net consumption's fields, returning the grid's new
+// state if it's changed
+func gridFields(fields map[string]interface{}, netWatts float64, at time.Time) string {
+	importWatts, exportWatts := math.Max(netWatts, 0), math.Max(-netWatts, 0)
+	fields["grid_import_watts"] = importWatts
+	fields["grid_export_watts"] = exportWatts
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	g := state.Grid
+	importCost, exportCredit := 0.0, 0.0
+	changed := ""
+	if at.After(g.Last) {
+		if day := at.Local().Format("2006-01-02"); day != g.Day {
+			g.Day, g.ImportWh, g.ExportWh, g.ImportCost, g.ExportCredit = day, 0, 0, 0, 0
+		}
+		if dt := at.Sub(g.Last); !g.Last.IsZero() && dt <= gridMaxGap {
+			// Trapezoids, between this reading and the last
+			importWh := (g.ImportWatts + importWatts) / 2 * dt.Hours()
+			exportWh := (g.ExportWatts + exportWatts) / 2 * dt.Hours()
+			g.ImportWh += importWh
+			g.ExportWh += exportWh
+			importCost = importTariff.cost(importWh, at.Add(-dt/2))
+			exportCredit = exportTariff.cost(exportWh, at.Add(-dt/2))
+			g.ImportCost += importCost
+			g.ExportCredit += exportCredit
+		}
+		g.Last, g.ImportWatts, g.ExportWatts = at, importWatts, exportWatts
+		if now := gridState(netWatts, g.State); now != g.State {
+			if g.State != "" {
+				changed = now
+			}
+			g.State = now
+		}
+		state.Grid = g
+	}
+	fields["grid_import_wh_today"] = g.ImportWh
+	fields["grid_export_wh_today"] = g.ExportWh
+	if importTariff != nil {
+		fields["grid_import_cost"] = importCost
+		fields["grid_import_cost_today"] = g.ImportCost
+	}
+	if exportTariff != nil {
+		fields["grid_export_credit"] = exportCredit
+		fields["grid_export_credit_today"] = g.ExportCredit
+	}
+	return changed
+}
+
+// gridState is importing or exporting, going by net consumption, or the
+// state it was in while within gridDeadband
+func gridState(netWatts float64, was string) string {
+	switch {
+	case netWatts > gridDeadband:
+		return "importing"
+	case netWatts < -gridDeadband:
+		return "exporting"
+	}
+	return was
+}
+
+func gridStateChanged(now string, netWatts float64, at time.Time) busEvent {
+	title := "Importing from the grid"
+	if now == "exporting" {
+		title = "Exporting to the grid"
+	}
+	return busEvent{
+		Kind:  gridStateEvent,
+		Time:  at,
+		Type:  "grid",
+		Title: title,
+		Text:  fmt.Sprintf("Net consumption %.0f W", netWatts),
+	}
+}
@@ -0,0 +1,162 @@
+// Home Assistant MQTT discovery
+
+// With -ha-discovery, a retained discovery config is published alongside the
+// MQTT readings for each field Home Assistant has a sensor type for, so the
+// Envoy appears as a device with power, today's energy and battery sensors,
+// and a sensor for each inverter, meter or battery by its serial number or id.
+// Each config is published once per connection to the broker, and again only
+// if it changes.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"regexp"
+	"strings"
+)
+
+var (
+	haDiscoveryPtr       = flag.Bool("ha-discovery", false, "With the MQTT output, also publish Home Assistant discovery configs")
+	haDiscoveryPrefixPtr = flag.String("ha-discovery-prefix", "homeassistant", "Home Assistant discovery topic prefix")
+)
+
+type haSensor struct {
+	name        string
+	deviceClass string
+	unit        string
+	stateClass  string
+}
+
+// Sensor type for each field
+var haSensors = map[string]haSensor{
+	"watts":                {"Power", "power", "W", "measurement"},
+	"wh_today":             {"Energy Today", "energy", "Wh", "total_increasing"},
+	"wh_now":               {"Stored Energy", "energy_storage", "Wh", "measurement"},
+	"percent_full":         {"Battery Level", "battery", "%", "measurement"},
+	"grid_import_watts":    {"Grid Import", "power", "W", "measurement"},
+	"grid_export_watts":    {"Grid Export", "power", "W", "measurement"},
+	"grid_import_wh_today": {"Grid Import Today", "energy", "Wh", "total_increasing"},
+	"grid_export_wh_today": {"Grid Export Today", "energy", "Wh", "total_increasing"},
+	"volts":                {"Voltage", "voltage", "V", "measurement"},
+	"amps":                 {"Current", "current", "A", "measurement"},
+	"power_factor":         {"Power Factor", "power_factor", "", "measurement"},
+	"vars":                 {"Reactive Power", "reactive_power", "var", "measurement"},
+	"va":                   {"Apparent Power", "apparent_power", "VA", "measurement"},
+	// In -currency, with either schema
+	"grid_import_cost_today":   {"Grid Import Cost Today", "monetary", "", "total"},
+	"grid_export_credit_today": {"Grid Export Credit Today", "monetary", "", "total"},
+	"co2_avoided_g_today":      {"CO2 Avoided Today", "weight", "g", "total_increasing"},
+	// With -schema 2
+	"power_w":                     {"Power", "power", "W", "measurement"},
+	"energy_today_wh":             {"Energy Today", "energy", "Wh", "total_increasing"},
+	"energy_wh":                   {"Stored Energy", "energy_storage", "Wh", "measurement"},
+	"charge_percent":              {"Battery Level", "battery", "%", "measurement"},
+	"grid_import_power_w":         {"Grid Import", "power", "W", "measurement"},
+	"grid_export_power_w":         {"Grid Export", "power", "W", "measurement"},
+	"grid_import_energy_today_wh": {"Grid Import Today", "energy", "Wh", "total_increasing"},
+	"grid_export_energy_today_wh": {"Grid Export Today", "energy", "Wh", "total_increasing"},
+	"voltage_v":                   {"Voltage", "voltage", "V", "measurement"},
+	"current_a":                   {"Current", "current", "A", "measurement"},
+	"reactive_power_var":          {"Reactive Power", "reactive_power", "var", "measurement"},
+	"apparent_power_va":           {"Apparent Power", "apparent_power", "VA", "measurement"},
+	"co2_avoided_today_g":         {"CO2 Avoided Today", "weight", "g", "total_increasing"},
+}
+
+// Tags which don't tell one device's readings from another's
+var haSharedTags = map[string]bool{
+	"type":              true,
+	"phase":             true,
+	"site":              true,
+	"collector_version": true,
+}
+
+var haIdRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+func haId(s string) string {
+	return strings.ToLower(haIdRegexp.ReplaceAllString(s, "_"))
+}
+
+// haName turns e.g. total-consumption into Total Consumption
+func haName(s string) string {
+	words := strings.Split(s, "-")
+	for i, word := range words {
+		if word != "" {
+			words[i] = strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func publishDiscovery(ctx context.Context, s *mqttSession, pts []*client.Point) error {
+	node := haId("envoy_" + *envoyHostPtr)
+	device := map[string]interface{}{
+		"identifiers":  []string{node},
+		"name":         "Enphase Envoy",
+		"manufacturer": "Enphase",
+		"model":        "Envoy",
+	}
+
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		tags := pt.Tags()
+		// Only the totals of per-phase readings
+		if phase := tags["phase"]; phase != "" && phase != "total" {
+			continue
+		}
+		source := tags["type"]
+		if source == "" {
+			source = pt.Name()
+		}
+		// e.g. the serial number of each inverter
+		ids := []string{}
+		for _, k := range sortedKeys(tags) {
+			if !haSharedTags[k] {
+				ids = append(ids, tags[k])
+			}
+		}
+		label := strings.Join(append([]string{haName(source)}, ids...), " ")
+
+		for field := range fields {
+			sensor, ok := haSensors[field]
+			if !ok {
+				continue
+			}
+			object := haId(strings.Join(append(append([]string{pt.Name(), source}, ids...), field), "_"))
+			config := map[string]interface{}{
+				"name":                label + " " + sensor.name,
+				"unique_id":           node + "_" + object,
+				"state_topic":         mqttTopic(pt),
+				"value_template":      "{{ value_json." + field + " }}",
+				"device_class":        sensor.deviceClass,
+				"unit_of_measurement": sensor.unit,
+				"state_class":         sensor.stateClass,
+				"device":              device,
+			}
+			// Power factor has no unit, and costs are in -currency
+			if sensor.deviceClass == "monetary" {
+				config["unit_of_measurement"] = *currencyPtr
+			} else if sensor.unit == "" {
+				delete(config, "unit_of_measurement")
+			}
+			payload, err := json.Marshal(config)
+			if err != nil {
+				return err
+			}
+			topic := *haDiscoveryPrefixPtr + "/sensor/" + node + "/" + object + "/config"
+			if s.discovered[topic] == string(payload) {
+				continue
+			}
+			if err := mqttWait(ctx, s.client.Publish(topic, byte(*mqttQosPtr), true, payload)); err != nil {
+				return err
+			}
+			s.discovered[topic] = string(payload)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Health checks
+
+// With -health-file, a daemon records the outcome of each collector's last
+// cycle, and `influxEnvoyStats -health-file <file> healthcheck` exits 0 only
+// if every collector's last cycle succeeded within -health-max-age, for Docker
+// HEALTHCHECK and Kubernetes exec probes.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	healthFilePtr   = flag.String("health-file", "", "In daemon mode, record the outcome of each collection in this file, for the healthcheck command")
+	healthMaxAgePtr = flag.Duration("health-max-age", time.Minute*5, "How recently the healthcheck command needs each collector to have succeeded")
+)
+
+// collectorHealth is the outcome of a collector's last cycle
+type collectorHealth struct {
+	LastCycle   time.Time `json:"last_cycle"`
+	LastSuccess time.Time `json:"last_success"`
+	Failures    int       `json:"failures"`
+	Error       string    `json:"error,omitempty"`
+}
+
+var (
+	health   = map[string]*collectorHealth{}
+	healthMu sync.Mutex
+)
+
+// healthSnapshot copies each collector's health
+func healthSnapshot() map[string]collectorHealth {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	collectors := map[string]collectorHealth{}
+	for name, h := range health {
+		collectors[name] = *h
+	}
+	return collectors
+}
+
+// recordHealth notes how a collector's cycle started at start went, and writes
+// the health file
+func recordHealth(collector string, start time.Time, err error) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	h, ok := health[collector]
+	if !ok {
+		h = &collectorHealth{}
+		health[collector] = h
+	}
+	h.LastCycle = start
+	h.Error = ""
+	if err != nil {
+		h.Error = redactSecrets(err.Error())
+		h.Failures++
+	} else {
+		h.LastSuccess = start
+	}
+
+	if *healthFilePtr == "" {
+		return
+	}
+	data, err := json.Marshal(health)
+	if err == nil {
+		// Replace the file whole, so the healthcheck never reads half of it
+		tmp := *healthFilePtr + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, *healthFilePtr)
+		}
+	}
+	if err != nil {
+		log.Printf(journalPriority(4, "Writing health file: %v"), err)
+	}
+}
+
+// healthcheck exits with status 1 unless every collector in the health file
+// last succeeded within -health-max-age
+func healthcheck() {
+	if err := checkHealth(); err != nil {
+		fmt.Fprintf(os.Stderr, "Unhealthy: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Healthy")
+}
+
+func checkHealth() error {
+	if *healthFilePtr == "" {
+		return fmt.Errorf("no -health-file")
+	}
+	data, err := ioutil.ReadFile(*healthFilePtr)
+	if err != nil {
+		return err
+	}
+	collectors := map[string]collectorHealth{}
+	if err = json.Unmarshal(data, &collectors); err != nil {
+		return fmt.Errorf("%s: %v", *healthFilePtr, err)
+	}
+	return unhealthy(collectors)
+}
+
+// unhealthy gives why collectors aren't healthy, or nil if they are
+func unhealthy(collectors map[string]collectorHealth) error {
+	if len(collectors) == 0 {
+		return fmt.Errorf("no collections yet")
+	}
+	for _, name := range sortedKeys(collectors) {
+		h := collectors[name]
+		if h.Error != "" {
+			return fmt.Errorf("%s collection at %s failed: %s", name, h.LastCycle.Format(time.RFC3339), h.Error)
+		}
+		if age := time.Since(h.LastSuccess); age > *healthMaxAgePtr {
+			return fmt.Errorf("%s last collected %s ago", name, age.Round(time.Second))
+		}
+	}
+	return nil
+}
@@ -2,6 +2,12 @@
 
 // For options:
 // > influxEnvoyStats -h
+// For the measurements, tags and fields written:
+// > influxEnvoyStats schema
+// For whether a daemon's last collections succeeded:
+// > influxEnvoyStats -health-file <file> healthcheck
+// For the latest readings written to SQLite:
+// > influxEnvoyStats sqlite-latest
 
 // API path used by the webpage provided by Envoy is e.g.:
 //  http://envoy/production.json?details=1
@@ -12,20 +18,54 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/influxdata/influxdb/client/v2"
-	"io/ioutil"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-func check(e error) {
-	if e != nil {
-		panic(e)
-	}
-}
+var (
+	envoyHostPtr        = flag.String("e", "envoy", "IP or hostname of Envoy, or auto to find it with mDNS")
+	influxAddrPtr       = flag.String("dba", "http://localhost:8086", "InfluxDB connection address")
+	dbNamePtr           = flag.String("dbn", "solar", "Influx database name to put readings in")
+	dbUserPtr           = flag.String("dbu", "user", "DB username")
+	dbPwPtr             = flag.String("dbp", "pw", "DB password (or DB_PW / DB_PW_FILE)")
+	influxVersionPtr    = flag.Int("influx-version", 1, "InfluxDB major version: 1, 2 to write with -influx-org/-influx-bucket/-influx-token, or 3 to write to the -dbn database with -influx-token")
+	influxOrgPtr        = flag.String("influx-org", "", "InfluxDB 2 organization")
+	influxBucketPtr     = flag.String("influx-bucket", "", "InfluxDB 2 bucket (default the -dbn database name)")
+	influxTokenPtr      = flag.String("influx-token", "", "InfluxDB 2/3 API token (or INFLUX_TOKEN / INFLUX_TOKEN_FILE)")
+	measurementNamePtr  = flag.String("m", "readings", "Influx measurement name customisation (table name equivalent)")
+	envoyUserPtr        = flag.String("envoy-user", "envoy", "Envoy username for digest authentication")
+	envoyPwPtr          = flag.String("envoy-pw", "", "Envoy password for digest authentication (or ENVOY_PASSWORD / ENVOY_PASSWORD_FILE)")
+	envoyTokenPtr       = flag.String("envoy-token", "", "Envoy access token for firmware 7+, connects with HTTPS (or ENVOY_TOKEN / ENVOY_TOKEN_FILE)")
+	vaultAddrPtr        = flag.String("vault-addr", "", "HashiCorp Vault address to read credentials from (or VAULT_ADDR)")
+	vaultSecretPtr      = flag.String("vault-secret", "secret/envoy", "Vault KV v2 secret holding credentials, as mount/path")
+	envoyProxyPtr       = flag.String("envoy-proxy", "", "Proxy URL for reaching the Envoy, \"none\" to connect directly (default honors HTTP_PROXY/NO_PROXY)")
+	dbProxyPtr          = flag.String("db-proxy", "", "Proxy URL for reaching InfluxDB, \"none\" to connect directly (default honors HTTP_PROXY/NO_PROXY)")
+	standbyReportPtr    = flag.Bool("standby-report", false, "Instead of polling the Envoy, report monthly standby energy used by the PV system at night")
+	anomalyWindowPtr    = flag.Duration("anomaly-window", 0, "Raise an event when total consumption has stayed above its usual base load for this long, e.g. 2h (0 disables)")
+	anomalyWattsPtr     = flag.Float64("anomaly-watts", 300, "How far above the usual base load consumption must stay to raise an anomaly event")
+	dailyCurvePtr       = flag.String("daily-curve", "", "Instead of polling the Envoy, store the most production in each 5 minutes of a day (YYYY-MM-DD or \"yesterday\") compactly in the daily_curves measurement")
+	outputPtr           = flag.String("output", "influx", "Comma separated outputs to write readings to: influx, influx-secondary, adx, amqp, bigquery, clickhouse, csv, domoticz, emoncms, exec, jsonl, kafka, lineprotocol, mqtt, nats, openhab, postgres, pvoutput, questdb, redis, s3, socket, sparkplug, sqlite, statsd, timestream, vm, webhook")
+	outputRetriesPtr    = flag.Int("output-retries", 2, "How many times to retry a failed write to an output")
+	outputRetryWaitPtr  = flag.Duration("output-retry-wait", time.Second, "Wait before retrying a failed output write, doubling each retry")
+	outputBufferPtr     = flag.Int("output-buffer", 10000, "Most points to keep per output for retrying after failed writes")
+	prometheusListenPtr = flag.String("prometheus-listen", "", "Instead of writing to InfluxDB, serve readings for Prometheus on this address's /metrics, e.g. :9101")
+	prometheusInfluxPtr = flag.Bool("prometheus-influx", false, "With -prometheus-listen, also write the readings collected for each scrape to InfluxDB")
+	writeOrderPtr       = flag.String("write-order", "relaxed", "Per series write ordering: \"relaxed\" sorts each batch by time, \"strict\" also drops points older than one already written")
+	verbosePtr          = flag.Bool("v", false, "Log the effective configuration at startup, and in daemon mode how long each collection took")
+	simulateDaysPtr     = flag.Int("simulate-days", 0, "Instead of polling the Envoy, write this many days of back-dated simulated readings to each -output (for demos)")
+)
 
 type EnvoyAPIMeasurement struct {
 	Production  json.RawMessage
@@ -37,6 +77,16 @@ type Inverters struct {
 	ActiveCount int
 }
 
+type Storage struct {
+	Type        string
+	ActiveCount int
+	ReadingTime int64
+	WNow        float64
+	WhNow       float64
+	State       string
+	PercentFull *float64
+}
+
 type Eim struct {
 	MeasurementType  string
 	ReadingTime      int64
@@ -55,90 +105,558 @@ type Eim struct {
 	VahToday         float64
 	VarhLeadToday    float64
 	VarhLagToday     float64
+	// Each phase's readings, e.g. of a split phase or three phase meter
+	Lines []Eim
 }
 
 func main() {
-	envoyHostPtr := flag.String("e", "envoy", "IP or hostname of Envoy")
-	influxAddrPtr := flag.String("dba", "http://localhost:8086", "InfluxDB connection address")
-	dbNamePtr := flag.String("dbn", "solar", "Influx database name to put readings in")
-	dbUserPtr := flag.String("dbu", "user", "DB username")
-	dbPwPtr := flag.String("dbp", "pw", "DB password")
-	measurementNamePtr := flag.String("m", "readings", "Influx measurement name customisation (table name equivalent)")
+	log.SetOutput(redactingWriter{os.Stderr})
+	if err := run(); err != nil {
+		publishError(err)
+		log.Printf(journalPriority(3, "Error: %v"), err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// run does what the command and flags say, returning what it failed with
+func run() error {
 	flag.Parse()
+	command, args, err := parseCommand()
+	if err != nil {
+		return err
+	}
+	if underJournal() {
+		log.SetFlags(0)
+		logPriorities = true
+	}
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	if err := settingsFromEnv(); err != nil {
+		return err
+	}
+	switch command {
+	case "", "check", "dump", "migrate", "schema", "sqlite-latest", "service":
+	case "version":
+		printVersion()
+		return nil
+	case "config":
+		return configCommand(args)
+	case "discover":
+		return discoverCommand()
+	// Checked often, so without reading secrets from Vault or AWS
+	case "healthcheck":
+		healthcheck()
+		return nil
+	case "run":
+		if !daemonMode() {
+			if err := deriveFlag("interval", "1m", "run command"); err != nil {
+				return err
+			}
+		}
+	case "once":
+		if err := deriveFlag("interval", "0", "once command"); err != nil {
+			return err
+		}
+		if err := deriveFlag("schedule", "", "once command"); err != nil {
+			return err
+		}
+	default:
+		return configError("unknown command %q, see -h", command)
+	}
+	for _, setup := range []func() error{setupLowMemory, setupSchema, secretsFromEnv} {
+		if err := setup(); err != nil {
+			return err
+		}
+	}
+	for _, setup := range []func(context.Context) error{secretsFromVault, secretsFromAWS} {
+		if err := setup(context.Background()); err != nil {
+			return err
+		}
+	}
+	if *envoyHostPtr == "auto" {
+		if err := discoverEnvoy(); err != nil {
+			return err
+		}
+	}
+	if *printConfigPtr {
+		fmt.Print(getConfig())
+		return nil
+	}
+	if err := setupCollect(); err != nil {
+		return err
+	}
+	if err := loadState(); err != nil {
+		return err
+	}
+	if *verbosePtr {
+		log.Printf("Config:\n%s", getConfig())
+	}
 
-	envoyUrl := "http://" + *envoyHostPtr + "/production.json?details=1"
-	envoyClient := http.Client{
-		Timeout: time.Second * 2, // Maximum of 2 secs
+	if err := setupOutputs(); err != nil {
+		return err
+	}
+	defer closeOutputConns()
+	subscribe(dataReadyEvent, writeCollected)
+	// Anomalies are looked for while -anomaly-window is set, which a reload
+	// can change
+	subscribe(dataReadyEvent, func(ctx context.Context, e busEvent) error {
+		if *anomalyWindowPtr == 0 {
+			return nil
+		}
+		return detectAnomalies(ctx)
+	})
+	for _, kind := range []eventKind{alertEvent, gridStateEvent, inverterOfflineEvent, envoyLogEvent} {
+		subscribe(kind, writeEvent)
+	}
+	for _, kind := range hookEvents {
+		subscribe(kind, hookEvent)
+	}
+	if err := servePprof(); err != nil {
+		return err
 	}
-	req, err := http.NewRequest(http.MethodGet, envoyUrl, nil)
-	check(err)
-	resp, err := envoyClient.Do(req)
-	check(err)
-	jsonData, err := ioutil.ReadAll(resp.Body)
-	check(err)
+
+	switch command {
+	case "check":
+		return checkConnections()
+	case "dump":
+		return dumpEnvoy(args)
+	case "migrate":
+		return migrateCommand(args)
+	case "schema":
+		return printSchema()
+	case "sqlite-latest":
+		return printSQLiteLatest()
+	case "service":
+		return serviceCommand(flag.Arg(0))
+	}
+	if *simulateDaysPtr > 0 {
+		return simulate(*simulateDaysPtr)
+	}
+	if *standbyReportPtr {
+		return standbyReport()
+	}
+	if *dailyCurvePtr != "" {
+		return storeDailyCurve(*dailyCurvePtr)
+	}
+	if *batterySummaryPtr != "" {
+		return storeBatterySummary(*batterySummaryPtr)
+	}
+	if *prometheusListenPtr != "" {
+		return servePrometheus()
+	}
+
+	service, err := isWindowsService()
+	if err != nil {
+		return err
+	}
+	if service {
+		return runWindowsService()
+	}
+	if daemonMode() {
+		return runDaemon(context.Background())
+	}
+	return withCycleDeadline(collectOnce)
+}
+
+// collect reads production and consumption from the Envoy
+func collect(ctx context.Context) ([]*client.Point, error) {
+	resp, err := getEnvoy(ctx, "/production.json?details=1")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
 	var apiJsonObj struct {
 		Production  json.RawMessage
 		Consumption json.RawMessage
 		Storage     json.RawMessage
 	}
-	err = json.Unmarshal(jsonData, &apiJsonObj)
-	check(err)
+	if err := json.NewDecoder(resp.Body).Decode(&apiJsonObj); err != nil {
+		return nil, fmt.Errorf("decoding production.json: %w", err)
+	}
 
-	inverters := Inverters{}
+	readings := []Eim{}
 	prodReadings := Eim{}
-	productionObj := []interface{}{&inverters, &prodReadings}
-	err = json.Unmarshal(apiJsonObj.Production, &productionObj)
-	check(err)
+	if collecting("production") {
+		inverters := Inverters{}
+		productionObj := []interface{}{&inverters, &prodReadings}
+		if err := json.Unmarshal(apiJsonObj.Production, &productionObj); err != nil {
+			return nil, fmt.Errorf("decoding production: %w", err)
+		}
+		fmt.Fprintf(console, "%d production: %.3f\n", prodReadings.ReadingTime, prodReadings.WNow)
+	}
 
-	fmt.Printf("%d production: %.3f\n", prodReadings.ReadingTime, prodReadings.WNow)
+	if collecting("consumption") {
+		if err := json.Unmarshal(apiJsonObj.Consumption, &readings); err != nil {
+			return nil, fmt.Errorf("decoding consumption: %w", err)
+		}
+		for _, eim := range readings {
+			fmt.Fprintf(console, "%d %s: %.3f\n", eim.ReadingTime, eim.MeasurementType, eim.WNow)
+		}
+	}
 
-	consumptionReadings := []Eim{}
-	err = json.Unmarshal(apiJsonObj.Consumption, &consumptionReadings)
-	check(err)
-	for _, eim := range consumptionReadings {
-		fmt.Printf("%d %s: %.3f\n", eim.ReadingTime, eim.MeasurementType, eim.WNow)
+	storageReadings := []Storage{}
+	if collecting("storage") {
+		if err := json.Unmarshal(apiJsonObj.Storage, &storageReadings); err != nil {
+			return nil, fmt.Errorf("decoding storage: %w", err)
+		}
 	}
 
-	// Connect to influxdb specified in commandline arguments
+	pts := []*client.Point{}
+	if collecting("production") {
+		readings = append(readings, prodReadings)
+	}
+	for _, reading := range readings {
+		fields := readingFields(reading)
+		lifetimeCounterFields(reading.MeasurementType, fields, reading.WhLifetime)
+		if reading.MeasurementType == "net-consumption" {
+			if now := gridFields(fields, reading.WNow, time.Unix(reading.ReadingTime, 0)); now != "" {
+				notify(ctx, gridStateChanged(now, reading.WNow, time.Unix(reading.ReadingTime, 0)))
+			}
+		}
+		if reading.MeasurementType == "production" {
+			carbonFields(ctx, fields, reading.WNow, time.Unix(reading.ReadingTime, 0))
+		}
+		pt, err := readingPoint(reading.MeasurementType, fields, time.Unix(reading.ReadingTime, 0))
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, pt)
+		if collecting("phases") {
+			for i, line := range reading.Lines {
+				pt, err := phasePoint(reading, i, line)
+				if err != nil {
+					return nil, err
+				}
+				pts = append(pts, pt)
+			}
+		}
+	}
+	if collecting("production") {
+		pt, err := standbyPoint(prodReadings)
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, pt)
+	}
+	for _, storage := range storageReadings {
+		if storage.ActiveCount == 0 {
+			continue
+		}
+		fmt.Fprintf(console, "%d storage: %.3f\n", storage.ReadingTime, storage.WNow)
+		pt, err := storagePoint(storage)
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, pt)
+	}
+	return pts, nil
+}
+
+// Most of a response to read from the Envoy, so a misbehaving one can't use up
+// the memory of a small device
+const envoyMaxResponse = 16 << 20
+
+// getEnvoy requests a path from the Envoy, failing on anything but 200 OK, for
+// decoding as it's read
+func getEnvoy(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, envoyScheme()+"://"+*envoyHostPtr+path, nil)
+	if err != nil {
+		return nil, configError("Envoy %s: %v", path, err)
+	}
+	resp, err := envoyHTTPClient().Do(req)
+	if err != nil {
+		return nil, exitError{exitEnvoyUnreachable, err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("Envoy %s: %s", req.URL.Path, resp.Status)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			err = exitError{exitEnvoyAuth, err}
+		}
+		return nil, err
+	}
+	resp.Body = &cappedBody{ReadCloser: resp.Body, left: envoyMaxResponse}
+	return resp, nil
+}
+
+// cappedBody fails reading a response body beyond a size
+type cappedBody struct {
+	io.ReadCloser
+	left int64
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		return 0, fmt.Errorf("Envoy response larger than %d bytes", envoyMaxResponse)
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+// The Envoy client, kept for reusing its connection
+var (
+	envoyClient     *http.Client
+	envoyClientOnce sync.Once
+)
+
+func envoyHTTPClient() *http.Client {
+	envoyClientOnce.Do(func() {
+		envoyClient = &http.Client{
+			// Timing out is left to envoyLimitTransport, so waiting to make a
+			// request doesn't count
+			Transport: &envoyRetryTransport{
+				base: &envoyAuthTransport{
+					base: &envoyLimitTransport{
+						base: &http.Transport{
+							Proxy: proxyFunc(*envoyProxyPtr),
+							// Envoys only have a self-signed certificate
+							TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+						},
+					},
+				},
+			},
+		}
+	})
+	return envoyClient
+}
+
+// readingFields are the fields stored for a reading, but for its lifetime
+// counter (see counters.go)
+func readingFields(reading Eim) map[string]interface{} {
+	return map[string]interface{}{
+		"watts":              reading.WNow,
+		"wh_today":           reading.WhToday,
+		"wh_last_seven_days": reading.WhLastSevenDays,
+		"volts":              reading.RmsVoltage,
+		"amps":               reading.RmsCurrent,
+		"power_factor":       reading.PwrFactor,
+		"vars":               reading.ReactPwr,
+		"va":                 reading.ApprntPwr,
+		"vah_today":          reading.VahToday,
+		"vah_lifetime":       reading.VahLifetime,
+		"varh_lead_today":    reading.VarhLeadToday,
+		"varh_lag_today":     reading.VarhLagToday,
+		"varh_lead_lifetime": reading.VarhLeadLifetime,
+		"varh_lag_lifetime":  reading.VarhLagLifetime,
+	}
+}
+
+// readingPoint builds the point stored for a single reading
+func readingPoint(measurementType string, fields map[string]interface{}, createdTime time.Time) (*client.Point, error) {
+	tags := map[string]string{
+		"type": measurementType,
+	}
+	return newPoint(*measurementNamePtr, tags, fields, createdTime)
+}
+
+// newPoint builds a point, failing with which point it was
+func newPoint(name string, tags map[string]string, fields map[string]interface{}, t time.Time) (*client.Point, error) {
+	pt, err := client.NewPoint(name, tags, fields, t)
+	if err != nil {
+		return nil, fmt.Errorf("%s point %v: %w", name, tags, err)
+	}
+	return pt, nil
+}
+
+// storagePoint builds the point stored for a battery (e.g. "acb") reading,
+// tagged with the kind of battery, so a system with more than one kind has a
+// series for each
+func storagePoint(storage Storage) (*client.Point, error) {
+	tags := map[string]string{
+		"type": "storage",
+	}
+	if storage.Type != "" {
+		tags["storage"] = strings.ToLower(storage.Type)
+	}
+	fields := map[string]interface{}{
+		"watts":  storage.WNow,
+		"wh_now": storage.WhNow,
+	}
+	if storage.PercentFull != nil {
+		fields["percent_full"] = *storage.PercentFull
+	}
+	return newPoint(*measurementNamePtr, tags, fields, time.Unix(storage.ReadingTime, 0))
+}
+
+// influxTarget is an InfluxDB to write to
+type influxTarget struct {
+	addr     string
+	database string
+	user     string
+	password string
+	version  int
+	org      string
+	bucket   string
+	token    string
+}
+
+// primaryInflux is the InfluxDB given by -dba etc.
+func primaryInflux() influxTarget {
+	return influxTarget{
+		addr:     *influxAddrPtr,
+		database: *dbNamePtr,
+		user:     *dbUserPtr,
+		password: *dbPwPtr,
+		version:  *influxVersionPtr,
+		org:      *influxOrgPtr,
+		bucket:   *influxBucketPtr,
+		token:    *influxTokenPtr,
+	}
+}
+
+// newInfluxClient connects to the influxdb specified in commandline arguments.
+// InfluxDB 2 and 3 are queried with InfluxQL through their v1 compatibility
+// APIs; InfluxDB 2 needs a DBRP mapping from the -dbn database name to the bucket.
+func newInfluxClient() (client.Client, error) {
+	return primaryInflux().client()
+}
+
+// Clients for each InfluxDB, kept for reusing their connections
+var (
+	influxClients   = map[influxTarget]client.Client{}
+	influxClientsMu sync.Mutex
+)
+
+// client returns the client for the target, connecting on first use
+func (t influxTarget) client() (client.Client, error) {
+	influxClientsMu.Lock()
+	defer influxClientsMu.Unlock()
+	if c, ok := influxClients[t]; ok {
+		return c, nil
+	}
+
+	password := t.password
+	if t.version >= 2 {
+		password = t.token
+	}
 	c, err := client.NewHTTPClient(client.HTTPConfig{
-		Addr:     *influxAddrPtr,
-		Username: *dbUserPtr,
-		Password: *dbPwPtr,
+		Addr:     t.addr,
+		Username: t.user,
+		Password: password,
+		Proxy:    proxyFunc(*dbProxyPtr),
+		Timeout:  *influxTimeoutPtr,
 	})
-	check(err)
-	defer c.Close()
+	if err != nil {
+		return nil, configError("InfluxDB %s: %v", t.addr, err)
+	}
+	influxClients[t] = c
+	return c, nil
+}
 
-	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  *dbNamePtr,
-		Precision: "s",
+// closeInfluxClients closes the clients, so that they're connected again with
+// the settings as they are when next used
+func closeInfluxClients() {
+	influxClientsMu.Lock()
+	defer influxClientsMu.Unlock()
+	for t, c := range influxClients {
+		c.Close()
+		delete(influxClients, t)
+	}
+}
+
+// queryValues runs an InfluxQL query and returns the values of the first series
+func queryValues(c client.Client, cmd string) ([][]interface{}, error) {
+	resp, err := c.Query(client.NewQuery(cmd, *dbNamePtr, ""))
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying InfluxDB: %w", err)
+	}
+	if len(resp.Results) == 0 || len(resp.Results[0].Series) == 0 {
+		return nil, nil
+	}
+	return resp.Results[0].Series[0].Values, nil
+}
+
+// queryFloat returns the single value of an aggregate query
+func queryFloat(c client.Client, cmd string) (float64, bool, error) {
+	values, err := queryValues(c, cmd)
+	if err != nil || len(values) == 0 {
+		return 0, false, err
+	}
+	n, ok := values[0][1].(json.Number)
+	if !ok {
+		return 0, false, nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false, fmt.Errorf("querying InfluxDB: %w", err)
+	}
+	return f, true, nil
+}
+
+// influxDuration formats a duration as an InfluxQL duration literal
+func influxDuration(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d.Seconds()))
+}
+
+// writePoints writes a batch of points to influxdb
+func writePoints(ctx context.Context, pts []*client.Point) error {
+	if *dryRunPtr {
+		printDryRun([]string{"influx"}, pts)
+		return nil
+	}
+	if *influxUDPPtr != "" {
+		return writePointsUDP(ctx, pts)
+	}
+	return primaryInflux().write(ctx, pts)
+}
+
+func (t influxTarget) write(ctx context.Context, pts []*client.Point) error {
+	addr := t.addr + "/" + t.database
+	pts = orderPoints(addr, pts)
+	err := retryInflux(ctx, func() error {
+		return t.writeBatch(ctx, pts)
 	})
-	check(err)
+	if err == nil {
+		markWritten(addr, pts)
+	}
+	return err
+}
 
-	readings := append(consumptionReadings, prodReadings)
-	for _, reading := range readings {
-		tags := map[string]string{
-			"type": reading.MeasurementType,
-		}
-		fields := map[string]interface{}{
-			"watts": reading.WNow,
-		}
-		createdTime := time.Unix(reading.ReadingTime, 0)
-		check(err)
-		pt, err := client.NewPoint(
-			*measurementNamePtr,
-			tags,
-			fields,
-			createdTime,
-		)
-		check(err)
-		bp.AddPoint(pt)
+func (t influxTarget) writeBatch(ctx context.Context, pts []*client.Point) error {
+	switch t.version {
+	case 2:
+		return t.writeV2(ctx, pts)
+	case 3:
+		return t.writeV3(ctx, pts)
+	}
+	if *influxGzipPtr {
+		return t.writeGzip(ctx, pts)
 	}
 
+	c, err := t.client()
+	if err != nil {
+		return err
+	}
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:  t.database,
+		Precision: *precisionPtr,
+	})
+	if err != nil {
+		return configError("InfluxDB batch: %v", err)
+	}
+	bp.AddPoints(pts)
+
 	// Write the batch
-	err = c.Write(bp)
-	check(err)
+	if err := c.Write(bp); err != nil {
+		return fmt.Errorf("writing to InfluxDB %s: %w", t.addr, err)
+	}
+	return nil
+}
 
-	err = c.Close()
-	check(err)
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
@@ -0,0 +1,51 @@
+// InfluxDB write retries
+
+// With -influx-retry-for, a failed write to InfluxDB (e.g. a network blip or
+// the database restarting) is retried, backing off exponentially with jitter,
+// for up to that long before giving up, so one hiccup doesn't lose a whole
+// collection.  This is on top of the output retries, which still apply once
+// it's given up.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"math/rand"
+	"time"
+)
+
+var influxRetryForPtr = flag.Duration("influx-retry-for", 0, "Keep retrying a failed InfluxDB write for up to this long, backing off exponentially with jitter, e.g. 2m (0 fails straight away)")
+
+const (
+	influxRetryWait    = time.Second      // Wait before the first retry, doubling each retry
+	influxRetryMaxWait = time.Second * 30 // Longest wait between retries
+)
+
+// retryInflux runs write, retrying it until it succeeds or -influx-retry-for
+// has passed
+func retryInflux(ctx context.Context, write func() error) error {
+	giveUp := time.Now().Add(*influxRetryForPtr)
+	wait := influxRetryWait
+	for {
+		err := write()
+		if err == nil {
+			return nil
+		}
+		if !time.Now().Before(giveUp) || ctx.Err() != nil {
+			return err
+		}
+		// Wait between half and all of the backoff, so collectors don't retry
+		// in step, with a last try when it's time to give up
+		jittered := wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		if left := withinDeadline(ctx, time.Until(giveUp)); jittered > left {
+			jittered = left
+		}
+		log.Printf("InfluxDB write: %v, retrying in %s", err, jittered.Round(time.Millisecond))
+		time.Sleep(jittered)
+		if wait *= 2; wait > influxRetryMaxWait {
+			wait = influxRetryMaxWait
+		}
+	}
+}
@@ -0,0 +1,57 @@
+// Secondary InfluxDB output
+
+// Writes the same points to a second InfluxDB, e.g. during a migration or as a
+// standby for the primary.  As a separate output it has its own retries and
+// buffer, so either database being down doesn't stop writes to the other.
+// Settings not given for the secondary are the same as the primary's.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+var (
+	secondaryAddrPtr          = flag.String("secondary-dba", "", "Secondary InfluxDB address, for the influx-secondary output")
+	secondaryDbNamePtr        = flag.String("secondary-dbn", "", "Secondary InfluxDB database name (default -dbn)")
+	secondaryDbUserPtr        = flag.String("secondary-dbu", "", "Secondary InfluxDB username (default -dbu)")
+	secondaryDbPwPtr          = flag.String("secondary-dbp", "", "Secondary InfluxDB password (default -dbp, or SECONDARY_DB_PW / SECONDARY_DB_PW_FILE)")
+	secondaryInfluxVersionPtr = flag.Int("secondary-influx-version", 0, "Secondary InfluxDB major version: 1, 2 or 3 (default -influx-version)")
+	secondaryInfluxOrgPtr     = flag.String("secondary-influx-org", "", "Secondary InfluxDB 2 organization (default -influx-org)")
+	secondaryInfluxBucketPtr  = flag.String("secondary-influx-bucket", "", "Secondary InfluxDB 2 bucket (default -influx-bucket)")
+	secondaryInfluxTokenPtr   = flag.String("secondary-influx-token", "", "Secondary InfluxDB 2/3 API token (default -influx-token, or SECONDARY_INFLUX_TOKEN / SECONDARY_INFLUX_TOKEN_FILE)")
+)
+
+func writeSecondaryInflux(ctx context.Context, pts []*client.Point) error {
+	t, err := secondaryInflux()
+	if err != nil {
+		return err
+	}
+	return t.write(ctx, pts)
+}
+
+// secondaryInflux is the InfluxDB given by -secondary-dba etc.
+func secondaryInflux() (influxTarget, error) {
+	if *secondaryAddrPtr == "" {
+		return influxTarget{}, configError("the influx-secondary output needs -secondary-dba")
+	}
+	t := primaryInflux()
+	t.addr = *secondaryAddrPtr
+	override := func(value *string, secondary string) {
+		if secondary != "" {
+			*value = secondary
+		}
+	}
+	override(&t.database, *secondaryDbNamePtr)
+	override(&t.user, *secondaryDbUserPtr)
+	override(&t.password, *secondaryDbPwPtr)
+	override(&t.org, *secondaryInfluxOrgPtr)
+	override(&t.bucket, *secondaryInfluxBucketPtr)
+	override(&t.token, *secondaryInfluxTokenPtr)
+	if *secondaryInfluxVersionPtr != 0 {
+		t.version = *secondaryInfluxVersionPtr
+	}
+	return t, nil
+}
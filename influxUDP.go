@@ -0,0 +1,45 @@
+// InfluxDB UDP writes
+
+// With -influx-udp the influx output sends line protocol to an InfluxDB 1.x UDP
+// listener instead of the HTTP API: fire and forget, with no response to wait
+// for and no error if the points are lost.  The database is the one the
+// listener is configured with, not -dbn.  Queries (e.g. for reports) still use
+// -dba.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+)
+
+var influxUDPPtr = flag.String("influx-udp", "", "Write to this InfluxDB 1.x UDP listener address instead of over HTTP, e.g. localhost:8089")
+
+// Largest datagram to send, which stays within a typical 1500 byte MTU
+const influxUDPPayloadSize = 1400
+
+var influxUDPConn = newOutputConn(func(context.Context) (client.Client, error) {
+	return client.NewUDPClient(client.UDPConfig{
+		Addr:        *influxUDPPtr,
+		PayloadSize: influxUDPPayloadSize,
+	})
+}, func(c client.Client) {
+	c.Close()
+})
+
+func writePointsUDP(ctx context.Context, pts []*client.Point) error {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{Precision: *precisionPtr})
+	if err != nil {
+		return err
+	}
+	pts = orderPoints(*influxUDPPtr, pts)
+	bp.AddPoints(pts)
+	err = influxUDPConn.use(ctx, func(c client.Client) error {
+		return c.Write(bp)
+	})
+	if err == nil {
+		markWritten(*influxUDPPtr, pts)
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+// InfluxDB 2.x output
+
+// Writes to an org and bucket with an API token, e.g.
+// > influxEnvoyStats -influx-version 2 -dba http://influx:8086 -influx-org home -influx-bucket solar -influx-token ...
+
+package main
+
+import (
+	"context"
+	"github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"sync"
+)
+
+// Clients for each InfluxDB 2, kept for reusing their connections
+var (
+	influxV2Clients   = map[influxTarget]influxdb2.Client{}
+	influxV2ClientsMu sync.Mutex
+)
+
+func (t influxTarget) clientV2() influxdb2.Client {
+	influxV2ClientsMu.Lock()
+	defer influxV2ClientsMu.Unlock()
+	if c, ok := influxV2Clients[t]; ok {
+		return c
+	}
+	options := influxdb2.DefaultOptions().
+		SetPrecision(precision()).
+		SetUseGZip(*influxGzipPtr).
+		SetHTTPClient(&http.Client{
+			Timeout: *influxTimeoutPtr,
+			Transport: &http.Transport{
+				Proxy: proxyFunc(*dbProxyPtr),
+			},
+		})
+	c := influxdb2.NewClientWithOptions(t.addr, t.token, options)
+	influxV2Clients[t] = c
+	return c
+}
+
+func (t influxTarget) writeV2(ctx context.Context, pts []*client.Point) error {
+	c := t.clientV2()
+
+	bucket := t.bucket
+	if bucket == "" {
+		bucket = t.database
+	}
+
+	v2Pts := make([]*write.Point, 0, len(pts))
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		v2Pts = append(v2Pts, write.NewPoint(pt.Name(), pt.Tags(), fields, pt.Time()))
+	}
+	err := c.WriteAPIBlocking(t.org, bucket).WritePoint(ctx, v2Pts...)
+	return err
+}
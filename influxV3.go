@@ -0,0 +1,43 @@
+// InfluxDB 3.x output
+
+// Writes to InfluxDB 3 (Core/Enterprise, Cloud Dedicated/Serverless) through its
+// v2 compatible write API, where the bucket is the -dbn database and the token
+// is a database token, e.g.
+// > influxEnvoyStats -influx-version 3 -dba https://cluster.influxdb.io -dbn solar -influx-token ...
+// Line protocol is gzipped, and timestamps are in seconds to match the readings.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (t influxTarget) writeV3(ctx context.Context, pts []*client.Point) error {
+	var body bytes.Buffer
+	for _, pt := range pts {
+		body.WriteString(pt.PrecisionString(*precisionPtr) + "\n")
+	}
+
+	params := url.Values{
+		"bucket":    {t.database},
+		"precision": {*precisionPtr},
+	}
+	gzipped, err := gzipBody(body.Bytes())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(t.addr, "/")+"/api/v2/write?"+params.Encode(), gzipped)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+t.token)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	_, err = doOutputRequest(ctx, req)
+	return err
+}
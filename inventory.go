@@ -0,0 +1,70 @@
+// Device inventory
+
+// With inventory in -collect, the state of each device the Envoy knows of,
+// microinverters (PCU), AC batteries (ACB) and relays (NSRB), is read from its
+// /inventory.json and written to the devices measurement, tagged with its
+// serial number and kind, e.g.
+//   devices,serial=121935144671,type=pcu producing=true,communicating=true,operating=true,status="envoy.global.ok"
+// so that one which stops communicating or producing shows up.  The time is
+// when the device last reported.
+
+package main
+
+import (
+	"context"
+	"github.com/influxdata/influxdb/client/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const devicesMeasurement = "devices"
+
+// inventoryDevice is a device from /inventory.json
+type inventoryDevice struct {
+	SerialNum     string   `json:"serial_num"`
+	LastRptDate   string   `json:"last_rpt_date"`
+	DeviceStatus  []string `json:"device_status"`
+	Producing     bool     `json:"producing"`
+	Communicating bool     `json:"communicating"`
+	Operating     bool     `json:"operating"`
+}
+
+func collectInventory(ctx context.Context) ([]*client.Point, error) {
+	inventory := []struct {
+		Type    string
+		Devices []inventoryDevice
+	}{}
+	if err := getEnvoyJSON(ctx, "/inventory.json", &inventory); err != nil {
+		return nil, err
+	}
+	pts := []*client.Point{}
+	for _, kind := range inventory {
+		for _, device := range kind.Devices {
+			pt, err := devicePoint(strings.ToLower(kind.Type), device)
+			if err != nil {
+				return nil, err
+			}
+			pts = append(pts, pt)
+		}
+	}
+	return pts, nil
+}
+
+func devicePoint(kind string, device inventoryDevice) (*client.Point, error) {
+	tags := map[string]string{
+		"serial": device.SerialNum,
+		"type":   kind,
+	}
+	fields := map[string]interface{}{
+		"producing":     device.Producing,
+		"communicating": device.Communicating,
+		"operating":     device.Operating,
+		"status":        strings.Join(device.DeviceStatus, ","),
+	}
+	reported := time.Now()
+	if seconds, err := strconv.ParseInt(device.LastRptDate, 10, 64); err == nil && seconds > 0 {
+		reported = time.Unix(seconds, 0)
+	}
+	return newPoint(devicesMeasurement, tags, fields, reported)
+}
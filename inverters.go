@@ -0,0 +1,114 @@
+// Per-inverter production
+
+// Reads each microinverter's last reported power from the Envoy's
+// /api/v1/production/inverters, which needs the installer or owner credentials
+// (-envoy-pw or -envoy-token).  Inverters only report every 5 minutes or so, so
+// collecting them much more often than that only repeats the same readings.
+// An inverter whose last report is inverterOfflineAfter older than the newest
+// is offline, which at night, when none report, none are.  Inverters going
+// offline are published as an event (see bus.go), once until they report
+// again, which with -state-file carries across runs.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const invertersMeasurement = "inverters"
+
+// How much older than the newest report an inverter's last has to be for it to
+// be offline
+const inverterOfflineAfter = 30 * time.Minute
+
+type inverterReading struct {
+	SerialNumber    string
+	LastReportDate  int64
+	DevType         int
+	LastReportWatts float64
+	MaxReportWatts  float64
+}
+
+func collectInverters(ctx context.Context) ([]*client.Point, error) {
+	resp, err := getEnvoy(ctx, "/api/v1/production/inverters")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	readings := []inverterReading{}
+	if err := json.NewDecoder(resp.Body).Decode(&readings); err != nil {
+		return nil, fmt.Errorf("decoding /api/v1/production/inverters: %w", err)
+	}
+	pts := []*client.Point{}
+	for _, reading := range readings {
+		pt, err := inverterPoint(reading)
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, pt)
+	}
+	if offline := invertersOffline(readings); len(offline) > 0 {
+		title := "Inverter offline"
+		if len(offline) > 1 {
+			title = fmt.Sprintf("%d inverters offline", len(offline))
+		}
+		notify(ctx, busEvent{
+			Kind:  inverterOfflineEvent,
+			Type:  "inverter_offline",
+			Title: title,
+			Text:  "Not reporting: " + strings.Join(offline, ", "),
+		})
+	}
+	return pts, nil
+}
+
+// invertersOffline notes which inverters are offline, returning those which
+// have gone offline since last collected, with when they last reported
+func invertersOffline(readings []inverterReading) []string {
+	newest := int64(0)
+	for _, reading := range readings {
+		if reading.LastReportDate > newest {
+			newest = reading.LastReportDate
+		}
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if state.Offline == nil {
+		state.Offline = map[string]time.Time{}
+	}
+	offline := []string{}
+	for _, reading := range readings {
+		last := time.Unix(reading.LastReportDate, 0)
+		silent := time.Unix(newest, 0).Sub(last) > inverterOfflineAfter
+		_, was := state.Offline[reading.SerialNumber]
+		if silent && !was {
+			state.Offline[reading.SerialNumber] = last
+			offline = append(offline, fmt.Sprintf("%s (last reported %s)", reading.SerialNumber, last.Local().Format("2006-01-02 15:04")))
+		} else if !silent && was {
+			delete(state.Offline, reading.SerialNumber)
+			log.Printf("Inverter %s is reporting again", reading.SerialNumber)
+		}
+	}
+	sort.Strings(offline)
+	return offline
+}
+
+func inverterPoint(reading inverterReading) (*client.Point, error) {
+	tags := map[string]string{
+		"serial": reading.SerialNumber,
+	}
+	fields := map[string]interface{}{
+		"watts":     reading.LastReportWatts,
+		"max_watts": reading.MaxReportWatts,
+	}
+	return newPoint(invertersMeasurement, tags, fields, time.Unix(reading.LastReportDate, 0))
+}
@@ -0,0 +1,68 @@
+// JSON Lines output
+
+// Writes one JSON object per point, to stdout or appended to a file, for piping
+// into jq, Logstash, Vector etc.  The schema is stable:
+//   {"time":"2018-12-15T03:05:46Z","measurement":"readings","tags":{"type":"production"},"fields":{"watts":2977.73,"wh_today":13318.305}}
+// When writing to stdout, the readings usually printed there go to stderr.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"io"
+	"os"
+	"time"
+)
+
+var jsonLinesPathPtr = flag.String("jsonl-path", "-", "File to append JSON lines to, - for stdout")
+
+type jsonLine struct {
+	Time        string                 `json:"time"`
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+}
+
+func writeJSONLines(ctx context.Context, pts []*client.Point) error {
+	var w io.Writer = os.Stdout
+	if *jsonLinesPathPtr != "-" {
+		f, err := os.OpenFile(*jsonLinesPathPtr, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	return encodeJSONLines(w, pts)
+}
+
+func encodeJSONLines(w io.Writer, pts []*client.Point) error {
+	enc := json.NewEncoder(w)
+	for _, pt := range pts {
+		line, err := newJSONLine(pt)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newJSONLine(pt *client.Point) (jsonLine, error) {
+	fields, err := pt.Fields()
+	if err != nil {
+		return jsonLine{}, err
+	}
+	return jsonLine{
+		Time:        pt.Time().UTC().Format(time.RFC3339Nano),
+		Measurement: pt.Name(),
+		Tags:        pt.Tags(),
+		Fields:      fields,
+	}, nil
+}
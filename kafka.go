@@ -0,0 +1,162 @@
+// Kafka output
+
+// Publishes a message per point to -kafka-topic, keyed by -kafka-key (so one
+// site's readings stay in order on one partition).  Messages are either JSON in
+// the same schema as the jsonl output, or Avro single object encoded with the
+// schema below.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/linkedin/goavro/v2"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"strings"
+	"time"
+)
+
+var (
+	kafkaBrokersPtr = flag.String("kafka-brokers", "localhost:9092", "Comma separated Kafka broker addresses")
+	kafkaTopicPtr   = flag.String("kafka-topic", "envoy-readings", "Kafka topic")
+	kafkaKeyPtr     = flag.String("kafka-key", "", "Kafka message key template, {measurement} and {<tag>} are replaced (default the Envoy host)")
+	kafkaFormatPtr  = flag.String("kafka-format", "json", "Kafka message format: json or avro")
+	kafkaSASLPtr    = flag.String("kafka-sasl", "", "Kafka SASL mechanism: plain, scram-sha-256 or scram-sha-512")
+	kafkaUserPtr    = flag.String("kafka-user", "", "Kafka SASL username")
+	kafkaPwPtr      = flag.String("kafka-pw", "", "Kafka SASL password (or KAFKA_PASSWORD / KAFKA_PASSWORD_FILE)")
+	kafkaTLSPtr     = flag.Bool("kafka-tls", false, "Connect to Kafka with TLS")
+)
+
+const kafkaAvroSchema = `{
+	"type": "record",
+	"name": "Reading",
+	"namespace": "envoy",
+	"fields": [
+		{"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "measurement", "type": "string"},
+		{"name": "tags", "type": {"type": "map", "values": "string"}},
+		{"name": "fields", "type": {"type": "map", "values": ["double", "long", "boolean", "string"]}}
+	]
+}`
+
+var kafkaConn = newOutputConn(func(context.Context) (*kafka.Writer, error) {
+	transport := &kafka.Transport{}
+	if *kafkaTLSPtr {
+		transport.TLS = &tls.Config{}
+	}
+	if *kafkaSASLPtr != "" {
+		mechanism, err := kafkaMechanism()
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+	return &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(*kafkaBrokersPtr, ",")...),
+		Topic:        *kafkaTopicPtr,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+	}, nil
+}, func(w *kafka.Writer) {
+	w.Close()
+})
+
+func writeKafka(ctx context.Context, pts []*client.Point) error {
+	var codec *goavro.Codec
+	if *kafkaFormatPtr == "avro" {
+		var err error
+		codec, err = goavro.NewCodec(kafkaAvroSchema)
+		if err != nil {
+			return err
+		}
+	}
+
+	keyTemplate := *kafkaKeyPtr
+	if keyTemplate == "" {
+		keyTemplate = *envoyHostPtr
+	}
+	msgs := make([]kafka.Message, len(pts))
+	for i, pt := range pts {
+		value, err := kafkaValue(codec, pt)
+		if err != nil {
+			return err
+		}
+		msgs[i] = kafka.Message{
+			Key:   []byte(pointTemplate(keyTemplate, pt)),
+			Value: value,
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	return kafkaConn.use(ctx, func(w *kafka.Writer) error {
+		return w.WriteMessages(ctx, msgs...)
+	})
+}
+
+func kafkaMechanism() (sasl.Mechanism, error) {
+	switch *kafkaSASLPtr {
+	case "plain":
+		return plain.Mechanism{Username: *kafkaUserPtr, Password: *kafkaPwPtr}, nil
+	case "scram-sha-256", "scram-sha-512":
+		algo := scram.SHA256
+		if *kafkaSASLPtr == "scram-sha-512" {
+			algo = scram.SHA512
+		}
+		return scram.Mechanism(algo, *kafkaUserPtr, *kafkaPwPtr)
+	}
+	return nil, configError("unknown kafka SASL mechanism %q", *kafkaSASLPtr)
+}
+
+// kafkaValue encodes a point as a message value, in Avro with codec
+func kafkaValue(codec *goavro.Codec, pt *client.Point) ([]byte, error) {
+	if codec == nil {
+		line, err := newJSONLine(pt)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(line)
+	}
+	native, err := kafkaAvroNative(pt)
+	if err != nil {
+		return nil, err
+	}
+	return codec.SingleFromNative(nil, native)
+}
+
+func kafkaAvroNative(pt *client.Point) (map[string]interface{}, error) {
+	fields, err := pt.Fields()
+	if err != nil {
+		return nil, err
+	}
+	avroFields := map[string]interface{}{}
+	for k, v := range fields {
+		avroType := "string"
+		switch v.(type) {
+		case float64:
+			avroType = "double"
+		case int64:
+			avroType = "long"
+		case bool:
+			avroType = "boolean"
+		}
+		avroFields[k] = goavro.Union(avroType, v)
+	}
+	tags := map[string]interface{}{}
+	for k, v := range pt.Tags() {
+		tags[k] = v
+	}
+	return map[string]interface{}{
+		"time":        pt.Time(),
+		"measurement": pt.Name(),
+		"tags":        tags,
+		"fields":      avroFields,
+	}, nil
+}
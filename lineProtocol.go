@@ -0,0 +1,70 @@
+// Line protocol output
+
+// Prints the line protocol for the points to stdout rather than writing it, so
+// this can be used as a Telegraf inputs.exec plugin, leaving buffering and
+// retries to Telegraf:
+//   [[inputs.exec]]
+//     commands = ["/usr/local/bin/influxEnvoyStats -e envoy -output lineprotocol"]
+//     data_format = "influx"
+// Timestamps are in nanoseconds, Telegraf's default precision.
+
+// Alternatively, sends it to a Telegraf socket_listener, with -socket-addr
+// given as tcp://host:8094, udp://host:8094 or unix:///path/to/socket.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+var socketAddrPtr = flag.String("socket-addr", "tcp://localhost:8094", "Telegraf socket_listener address: tcp://, udp://, unix:// or unixgram://")
+
+func writeLineProtocol(ctx context.Context, pts []*client.Point) error {
+	return writeLines(os.Stdout, pts)
+}
+
+func writeSocket(ctx context.Context, pts []*client.Point) error {
+	network, address := "tcp", *socketAddrPtr
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, address = address[:i], address[i+3:]
+	}
+	conn, err := (&net.Dialer{Timeout: time.Second * 10}).DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(withinDeadline(ctx, time.Second*30))); err != nil {
+		return err
+	}
+
+	if network == "udp" || network == "unixgram" {
+		// A datagram per line keeps each within the packet size
+		for _, pt := range pts {
+			_, err = conn.Write([]byte(pt.String() + "\n"))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writeLines(conn, pts)
+}
+
+func writeLines(w io.Writer, pts []*client.Point) error {
+	bw := bufio.NewWriter(w)
+	for _, pt := range pts {
+		_, err := bw.WriteString(pt.String() + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
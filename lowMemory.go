@@ -0,0 +1,66 @@
+// Low memory mode
+
+// For running for months on a Pi Zero or other device with 256MB or so of
+// memory, -low-memory keeps the daemon's heap within lowMemoryTarget, and its
+// RSS within lowMemoryRSS: fewer points are held for retrying and batching,
+// spool files are kept small, the Envoy is asked for one thing at a time,
+// outputs are written one after the other rather than all at once, and the Go
+// runtime collects garbage sooner, under a soft memory limit.  Flags given
+// explicitly are left as they are.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+)
+
+var lowMemoryPtr = flag.Bool("low-memory", false, fmt.Sprintf("Use as little memory as possible, e.g. on a Pi Zero, keeping the heap within %dMB and the RSS within %dMB with smaller buffers and a soft memory limit", lowMemoryTarget>>20, lowMemoryRSS>>20))
+
+// Heap size low memory mode aims to stay within
+const lowMemoryTarget = 32 << 20
+
+// RSS low memory mode stays within, with the runtime and code as well as the
+// heap, as lowMemory_test.go checks.  It's usually around 40MB.
+const lowMemoryRSS = 48 << 20
+
+// Flag values for low memory mode
+var lowMemoryFlags = map[string]string{
+	"output-buffer":      "500",
+	"spool-max":          strconv.Itoa(4 << 20),
+	"slow-queue":         "250",
+	"envoy-max-inflight": "1",
+}
+
+func setupLowMemory() error {
+	if !*lowMemoryPtr {
+		return nil
+	}
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, value := range lowMemoryFlags {
+		if !given[name] {
+			if err := deriveFlag(name, value, "-low-memory"); err != nil {
+				return err
+			}
+		}
+	}
+	// Batches held back until the next flush are limited too
+	if *flushIntervalPtr > 0 && !given["batch-size"] {
+		if err := deriveFlag("batch-size", "500", "-low-memory"); err != nil {
+			return err
+		}
+	}
+
+	debug.SetGCPercent(50)
+	// GOMEMLIMIT takes precedence, for tuning
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(lowMemoryTarget)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLowMemoryRSS collects, prepares and writes an Envoy's readings over and
+// over under -low-memory, checking the process's RSS never grows past
+// lowMemoryRSS
+func TestLowMemoryRSS(t *testing.T) {
+	envoy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "apiOutput.raw.json")
+	}))
+	defer envoy.Close()
+
+	saved, derived := flagValues(), copyMap(derivedFlags)
+	t.Cleanup(func() {
+		for name, value := range saved {
+			if flag.Lookup(name).Value.String() != value {
+				if err := flag.Set(name, value); err != nil {
+					t.Errorf("restoring -%s: %v", name, err)
+				}
+			}
+		}
+		restoreMap(derivedFlags, derived)
+		closeOutputConns()
+		console = os.Stdout
+		debug.SetGCPercent(100)
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+	for name, value := range map[string]string{
+		"low-memory":  "true",
+		"e":           strings.TrimPrefix(envoy.URL, "http://"),
+		"output":      "jsonl",
+		"jsonl-path":  filepath.Join(t.TempDir(), "readings.jsonl"),
+		"force-write": "true",
+	} {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	console = io.Discard
+	if err := setupLowMemory(); err != nil {
+		t.Fatal(err)
+	}
+	if err := setupOutputs(); err != nil {
+		t.Fatal(err)
+	}
+
+	var peak uint64
+	sample := func() {
+		if rss := residentBytes(); rss > peak {
+			peak = rss
+		}
+	}
+	for i := 0; i < 500; i++ {
+		pts, err := collect(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sample()
+		if pts, err = preparePoints(pts, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		sample()
+		if err := writeOutputs(context.Background(), pts); err != nil {
+			t.Fatal(err)
+		}
+		sample()
+	}
+	t.Logf("peak RSS %d bytes", peak)
+	if peak >= lowMemoryRSS {
+		t.Errorf("peak RSS %d bytes, want under %d", peak, lowMemoryRSS)
+	}
+}
+
+// residentBytes is the process's RSS, from /proc/self/statm, or where there's
+// none, the memory the Go runtime has from the OS, which it's within
+func residentBytes() uint64 {
+	if statm, err := os.ReadFile("/proc/self/statm"); err == nil {
+		if fields := strings.Fields(string(statm)); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys
+}
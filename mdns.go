@@ -0,0 +1,137 @@
+// Finding the Envoy with mDNS
+
+// Envoys announce themselves on the local network as _enphase-envoy._tcp with
+// mDNS.  With -e auto, that's asked for at startup and the first Envoy to
+// answer is used, by IP address, which also works where envoy.local doesn't
+// resolve, e.g. in a container on the host's network.  mDNS doesn't cross
+// routers, VLANs or Docker's bridge network; see discover.go for those.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/dns/dnsmessage"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envoyMDNSService = "_enphase-envoy._tcp.local."
+	// How long to wait for Envoys to answer
+	mdnsWait = 3 * time.Second
+)
+
+// discoveredEnvoy is an Envoy found on the network
+type discoveredEnvoy struct {
+	addr     string // IP address, with the port if it isn't 80
+	serial   string
+	firmware string
+}
+
+// discoverEnvoy sets -e to the address of the Envoy found with mDNS
+func discoverEnvoy() error {
+	found, err := browseEnvoys(mdnsWait)
+	if err != nil {
+		return fmt.Errorf("asking for Envoys with mDNS: %w", err)
+	}
+	if len(found) == 0 {
+		return exitError{exitEnvoyUnreachable, errors.New("no Envoy answered mDNS, give its address with -e")}
+	}
+	if len(found) > 1 {
+		for _, envoy := range found {
+			log.Printf("Found Envoy %s at %s", envoy.serial, envoy.addr)
+		}
+		log.Printf("Using the Envoy at %s, give another with -e", found[0].addr)
+	} else {
+		log.Printf("Found Envoy %s at %s", found[0].serial, found[0].addr)
+	}
+	return deriveFlag("e", found[0].addr, "mDNS")
+}
+
+// browseEnvoys asks for Envoys with mDNS, returning those which answered
+// within wait, by address
+func browseEnvoys(wait time.Duration) ([]discoveredEnvoy, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Asked from a port other than 5353, answers come straight back
+	query := dnsmessage.Message{Questions: []dnsmessage.Question{{
+		Name:  dnsmessage.MustNewName(envoyMDNSService),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	}}}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.WriteToUDP(packed, &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353})
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]discoveredEnvoy{}
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		if envoy, ok := parseEnvoyAnswer(buf[:n], from.IP); ok {
+			found[envoy.addr] = envoy
+		}
+	}
+
+	envoys := make([]discoveredEnvoy, 0, len(found))
+	for _, addr := range sortedKeys(found) {
+		envoys = append(envoys, found[addr])
+	}
+	return envoys, nil
+}
+
+// parseEnvoyAnswer reads an Envoy's address and serial number from an answer
+// to an mDNS query, from the address it came from if it doesn't give one
+func parseEnvoyAnswer(msg []byte, from net.IP) (discoveredEnvoy, bool) {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil || p.SkipAllQuestions() != nil {
+		return discoveredEnvoy{}, false
+	}
+	answers, err := p.AllAnswers()
+	if err != nil || p.SkipAllAuthorities() != nil {
+		return discoveredEnvoy{}, false
+	}
+	additionals, _ := p.AllAdditionals()
+
+	envoy := discoveredEnvoy{addr: from.String()}
+	isEnvoy := false
+	port := uint16(80)
+	for _, r := range append(answers, additionals...) {
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			isEnvoy = isEnvoy || strings.EqualFold(r.Header.Name.String(), envoyMDNSService)
+		case *dnsmessage.SRVResource:
+			port = body.Port
+		case *dnsmessage.AResource:
+			envoy.addr = net.IP(body.A[:]).String()
+		case *dnsmessage.TXTResource:
+			for _, txt := range body.TXT {
+				if serial := strings.TrimPrefix(txt, "serialnum="); serial != txt {
+					envoy.serial = serial
+				}
+			}
+		}
+	}
+	if port != 80 {
+		envoy.addr = net.JoinHostPort(envoy.addr, strconv.Itoa(int(port)))
+	}
+	return envoy, isEnvoy
+}
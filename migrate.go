@@ -0,0 +1,172 @@
+// Migrating to schema 2
+
+// The migrate command copies the readings written to InfluxDB with schema 1
+// into schema 2 (see schemaV2.go), a day at a time, so history carries on when
+// switching to -schema 2, e.g.
+// > influxEnvoyStats -schema 2 migrate
+// or migrate 30 for just the last 30 days.  Schema 1's measurements are left as
+// they are, and the statements to drop them printed, for once the copy has been
+// checked.  With -dry-run, the points are counted but not written.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"strconv"
+	"time"
+)
+
+func migrateCommand(args []string) error {
+	var since time.Time
+	if len(args) > 0 {
+		days, err := strconv.Atoi(args[0])
+		if err != nil || days < 1 {
+			return configError("migrate takes a number of days, not %q", args[0])
+		}
+		since = time.Now().AddDate(0, 0, -days)
+	}
+	serial := envoySerialNumber(context.Background())
+	if serial == "" {
+		return exitError{exitEnvoyUnreachable, errors.New("the Envoy's serial number is needed for the envoy_serial tag, check -e")}
+	}
+
+	c, err := newInfluxClient()
+	if err != nil {
+		return err
+	}
+	migrated := []string{}
+	for _, measurement := range []string{*measurementNamePtr, phasesMeasurement, invertersMeasurement} {
+		n, err := migrateMeasurement(c, measurement, since, serial)
+		if err != nil {
+			return fmt.Errorf("migrating %s: %w", measurement, err)
+		}
+		if n == 0 {
+			continue
+		}
+		fmt.Printf("%s: %d points copied\n", measurement, n)
+		migrated = append(migrated, measurement)
+	}
+	if len(migrated) == 0 {
+		fmt.Println("Nothing written with schema 1 to copy")
+		return nil
+	}
+	if *dryRunPtr {
+		fmt.Println("Nothing was written, as this was a dry run")
+		return nil
+	}
+	fmt.Println("Once the copy has been checked, schema 1's measurements can be dropped with:")
+	for _, measurement := range migrated {
+		fmt.Printf("  DROP MEASUREMENT %q\n", measurement)
+	}
+	return nil
+}
+
+// migrateMeasurement copies a schema 1 measurement's points since a time (or
+// all of them) into schema 2, returning how many were copied
+func migrateMeasurement(c client.Client, measurement string, since time.Time, serial string) (int, error) {
+	keys, err := queryValues(c, fmt.Sprintf(`SHOW FIELD KEYS FROM %q`, measurement))
+	if err != nil {
+		return 0, err
+	}
+	types := map[string]string{}
+	for _, row := range keys {
+		types[row[0].(string)] = row[1].(string)
+	}
+	first, err := queryValues(c, fmt.Sprintf(`SELECT * FROM %q ORDER BY time ASC LIMIT 1`, measurement))
+	if err != nil {
+		return 0, err
+	}
+	if len(types) == 0 || len(first) == 0 {
+		return 0, nil
+	}
+	firstTime, err := time.Parse(time.RFC3339Nano, first[0][0].(string))
+	if err != nil {
+		return 0, err
+	}
+	if since.Before(firstTime) {
+		since = firstTime
+	}
+
+	copied := 0
+	day := time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, time.Local)
+	for ; day.Before(time.Now()); day = day.AddDate(0, 0, 1) {
+		pts, err := queryPoints(c, fmt.Sprintf(`SELECT * FROM %q WHERE time >= '%s' AND time < '%s' GROUP BY *`,
+			measurement, day.Format(time.RFC3339), day.AddDate(0, 0, 1).Format(time.RFC3339)), measurement, types)
+		if err != nil {
+			return 0, err
+		}
+		if len(pts) == 0 {
+			continue
+		}
+		pts, err = v2Points(pts, schemaSite(), serial)
+		if err != nil {
+			return 0, err
+		}
+		if !*dryRunPtr {
+			if err := primaryInflux().write(context.Background(), pts); err != nil {
+				return 0, err
+			}
+		}
+		copied += len(pts)
+	}
+	return copied, nil
+}
+
+// queryPoints runs a query grouped by every tag, returning its rows as points
+// with the field types given
+func queryPoints(c client.Client, cmd, measurement string, types map[string]string) ([]*client.Point, error) {
+	resp, err := c.Query(client.NewQuery(cmd, *dbNamePtr, "s"))
+	if err == nil {
+		err = resp.Error()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying InfluxDB: %w", err)
+	}
+	pts := []*client.Point{}
+	for _, result := range resp.Results {
+		for _, series := range result.Series {
+			for _, row := range series.Values {
+				fields := map[string]interface{}{}
+				for i, column := range series.Columns[1:] {
+					value, err := queryFieldValue(row[i+1], types[column])
+					if err != nil {
+						return nil, err
+					}
+					if value != nil {
+						fields[column] = value
+					}
+				}
+				if len(fields) == 0 {
+					continue
+				}
+				seconds, err := row[0].(json.Number).Int64()
+				if err != nil {
+					return nil, err
+				}
+				pt, err := newPoint(measurement, series.Tags, fields, time.Unix(seconds, 0))
+				if err != nil {
+					return nil, err
+				}
+				pts = append(pts, pt)
+			}
+		}
+	}
+	return pts, nil
+}
+
+// queryFieldValue converts a value from a query to its field type, keeping
+// integers from being written back as floats
+func queryFieldValue(value interface{}, fieldType string) (interface{}, error) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return value, nil
+	}
+	if fieldType == "integer" {
+		return n.Int64()
+	}
+	return n.Float64()
+}
@@ -0,0 +1,138 @@
+// MQTT output
+
+// Publishes each point as a JSON object of its time, tags and fields, e.g.
+//   envoy/readings/production {"time":1544843146,"type":"production","watts":2977.73}
+// The topic is a template in which {measurement} and {<tag>} are replaced by
+// the point's measurement and tag values.  Use an ssl:// broker URL for TLS.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"time"
+)
+
+var (
+	mqttBrokerPtr   = flag.String("mqtt-broker", "tcp://localhost:1883", "MQTT broker URL, ssl://host:8883 for TLS")
+	mqttTopicPtr    = flag.String("mqtt-topic", "envoy/{measurement}/{type}/{phase}/{serial}", "MQTT topic template, {measurement} and {<tag>} are replaced, leaving out levels with tags a reading doesn't have")
+	mqttQosPtr      = flag.Int("mqtt-qos", 0, "MQTT QoS level: 0, 1 or 2")
+	mqttRetainPtr   = flag.Bool("mqtt-retain", false, "Publish MQTT messages as retained")
+	mqttUserPtr     = flag.String("mqtt-user", "", "MQTT username")
+	mqttPwPtr       = flag.String("mqtt-pw", "", "MQTT password (or MQTT_PASSWORD / MQTT_PASSWORD_FILE)")
+	mqttCAPtr       = flag.String("mqtt-ca", "", "CA certificate file to verify a TLS MQTT broker with (default system roots)")
+	mqttClientIdPtr = flag.String("mqtt-client-id", "influxEnvoyStats", "MQTT client ID")
+)
+
+const mqttTimeout = time.Second * 10
+
+func newMQTTClient(ctx context.Context) (mqtt.Client, error) {
+	opts, err := mqttOptions()
+	if err != nil {
+		return nil, err
+	}
+	c := mqtt.NewClient(opts)
+	if err := mqttWait(ctx, c.Connect()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// mqttOptions are the broker connection options from the -mqtt-* flags
+func mqttOptions() (*mqtt.ClientOptions, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(*mqttBrokerPtr).
+		SetClientID(*mqttClientIdPtr).
+		SetUsername(*mqttUserPtr).
+		SetPassword(*mqttPwPtr).
+		SetConnectTimeout(mqttTimeout)
+	if *mqttCAPtr != "" {
+		pem, err := ioutil.ReadFile(*mqttCAPtr)
+		if err != nil {
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, configError("no certificates in %s", *mqttCAPtr)
+		}
+		opts.SetTLSConfig(&tls.Config{RootCAs: roots})
+	}
+	return opts, nil
+}
+
+// mqttSession is the MQTT output's connection, with the Home Assistant
+// discovery configs published on it
+type mqttSession struct {
+	client     mqtt.Client
+	discovered map[string]string // Config by topic
+}
+
+var mqttConn = newOutputConn(func(ctx context.Context) (*mqttSession, error) {
+	c, err := newMQTTClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &mqttSession{client: c, discovered: map[string]string{}}, nil
+}, func(s *mqttSession) {
+	s.client.Disconnect(250)
+})
+
+func publishMQTT(ctx context.Context, pts []*client.Point) error {
+	return mqttConn.use(ctx, func(s *mqttSession) error {
+		c := s.client
+		if *haDiscoveryPtr {
+			if err := publishDiscovery(ctx, s, pts); err != nil {
+				return err
+			}
+		}
+		for _, pt := range pts {
+			payload, err := mqttJSON(pt)
+			if err != nil {
+				return err
+			}
+			if err := mqttWait(ctx, c.Publish(mqttTopic(pt), byte(*mqttQosPtr), *mqttRetainPtr, payload)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func mqttTopic(pt *client.Point) string {
+	return topicTemplate(*mqttTopicPtr, "/", pt)
+}
+
+func mqttPayload(pt *client.Point) (map[string]interface{}, error) {
+	payload, err := pt.Fields()
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range pt.Tags() {
+		payload[k] = v
+	}
+	payload["time"] = pt.Time().Unix()
+	return payload, nil
+}
+
+// mqttJSON is a point's payload as JSON
+func mqttJSON(pt *client.Point) ([]byte, error) {
+	payload, err := mqttPayload(pt)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+func mqttWait(ctx context.Context, token mqtt.Token) error {
+	if !token.WaitTimeout(withinDeadline(ctx, mqttTimeout)) {
+		return fmt.Errorf("mqtt %s: timed out", *mqttBrokerPtr)
+	}
+	return token.Error()
+}
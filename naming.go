@@ -0,0 +1,159 @@
+// Measurement, tag and field names
+
+// Readings can be written with other names, to fit a schema established by
+// another collector without breaking its dashboards.  -rename-measurements,
+// -rename-tags and -rename-fields map names to others, tag and field names
+// either everywhere or in one measurement as measurement.name, and then
+// -measurement-template and -field-template build the names written, from
+// {measurement}, {field} and {<tag>}, e.g. enphase_{measurement}.  In a
+// configuration file these are maps, e.g.
+//
+//	rename:
+//	  fields:
+//	    watts: watts_now
+//	    inverters.max_watts: peak_watts
+//	measurement-template: enphase_{measurement}
+//
+// -type-measurements writes readings of some types to measurements of their
+// own rather than the one tagged measurement, e.g.
+//	-type-measurements production=solar,total-consumption=consumption
+// for dashboards and retention policies organized by measurement.  Their type
+// tags are kept.  The reports and alert rules query readings by the names
+// they're written with, renamed and all.
+//
+// The renames are applied as readings are written, so -bounds and the like use
+// the original names, as do the outputs which pick out particular readings
+// (Domoticz, openHAB and PVOutput), which are given them unrenamed.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"strings"
+)
+
+var (
+	renameMeasurementsPtr  = flag.String("rename-measurements", "", "Comma separated old=new measurement names to write readings with, e.g. inverters=microinverters")
+	renameTagsPtr          = flag.String("rename-tags", "", "Comma separated old=new tag names, or measurement.old=new for one measurement, e.g. type=kind")
+	renameFieldsPtr        = flag.String("rename-fields", "", "Comma separated old=new field names, or measurement.old=new for one measurement, e.g. watts=watts_now")
+	measurementTemplatePtr = flag.String("measurement-template", "{measurement}", "Template for the measurement names written, {measurement} and {<tag>} are replaced, e.g. enphase_{measurement}")
+	typeMeasurementsPtr    = flag.String("type-measurements", "", "Comma separated type=measurement to write readings of those types to their own measurements, e.g. production=solar,total-consumption=consumption")
+	fieldTemplatePtr       = flag.String("field-template", "{field}", "Template for the field names written, {field}, {measurement} and {<tag>} are replaced, e.g. enphase_{field}")
+)
+
+// Outputs which pick out readings by their original names
+var originalNameOutputs = map[string]bool{
+	"domoticz": true,
+	"openhab":  true,
+	"pvoutput": true,
+}
+
+// renaming is whether readings are written with other names
+func renaming() bool {
+	return *renameMeasurementsPtr != "" || *typeMeasurementsPtr != "" || *renameTagsPtr != "" || *renameFieldsPtr != "" ||
+		*measurementTemplatePtr != "{measurement}" || *fieldTemplatePtr != "{field}"
+}
+
+// renamePoints gives points the names they're written with
+func renamePoints(pts []*client.Point) ([]*client.Point, error) {
+	if !renaming() {
+		return pts, nil
+	}
+	r := currentRenames()
+
+	renamed := make([]*client.Point, 0, len(pts))
+	for _, pt := range pts {
+		names := r.series(pt.Name(), pt.Tags())
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		renamedFields := map[string]interface{}{}
+		for k, v := range fields {
+			renamedFields[names.field(k)] = v
+		}
+
+		pt, err = newPoint(names.measurement, names.tags, renamedFields, pt.Time())
+		if err != nil {
+			return nil, err
+		}
+		renamed = append(renamed, pt)
+	}
+	return renamed, nil
+}
+
+// renames are the settings giving readings other names
+type renames struct {
+	measurements map[string]string
+	tags         map[string]string
+	fields       map[string]string
+	types        map[string]string
+}
+
+func currentRenames() renames {
+	return renames{
+		measurements: mapping("rename-measurements"),
+		tags:         mapping("rename-tags"),
+		fields:       mapping("rename-fields"),
+		types:        mapping("type-measurements"),
+	}
+}
+
+// seriesNames are the names a series is written with
+type seriesNames struct {
+	r             renames
+	original      string            // The measurement before renaming
+	measurement   string            // The measurement written to
+	tags          map[string]string // The tags, by the names written
+	fieldTemplate string
+}
+
+// series gives the names a measurement's series with tags is written with
+func (r renames) series(measurement string, tags map[string]string) seriesNames {
+	n := seriesNames{r: r, original: measurement, tags: map[string]string{}}
+	for k, v := range tags {
+		n.tags[renameKey(r.tags, measurement, k)] = v
+	}
+	name := measurement
+	if m, ok := r.types[tags["type"]]; ok && name == readingsMeasurement() {
+		name = m
+	} else if m, ok := r.measurements[name]; ok {
+		name = m
+	}
+	// Templates see the renamed measurement and tags
+	n.measurement = nameTemplate(*measurementTemplatePtr, name, n.tags)
+	n.fieldTemplate = nameTemplate(*fieldTemplatePtr, name, n.tags)
+	return n
+}
+
+// tag is the name a tag is written with
+func (n seriesNames) tag(k string) string {
+	return renameKey(n.r.tags, n.original, k)
+}
+
+// field is the name a field is written with
+func (n seriesNames) field(k string) string {
+	return strings.Replace(n.fieldTemplate, "{field}", renameKey(n.r.fields, n.original, k), -1)
+}
+
+// where is an InfluxQL condition matching the series' tags
+func (n seriesNames) where() string {
+	conditions := []string{}
+	for _, k := range sortedKeys(n.tags) {
+		conditions = append(conditions, fmt.Sprintf(`%q = '%s'`, k, n.tags[k]))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// renameKey looks up a tag or field's new name, for its measurement or any
+func renameKey(names map[string]string, measurement, key string) string {
+	if name, ok := names[measurement+"."+key]; ok {
+		return name
+	}
+	if name, ok := names[key]; ok {
+		return name
+	}
+	return key
+}
@@ -0,0 +1,101 @@
+// NATS output
+
+// Publishes each point as the same JSON object as the MQTT output, to a subject
+// template in which {measurement} and {<tag>} are replaced.  With -nats-stream
+// readings are published to a JetStream stream (created on first use covering
+// the subjects) and each publish waits for the server's acknowledgement.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"time"
+)
+
+var (
+	natsURLPtr     = flag.String("nats-url", nats.DefaultURL, "NATS server URL(s), comma separated, nats://user:pw@host:4222 for auth")
+	natsSubjectPtr = flag.String("nats-subject", "envoy.{measurement}.{type}.{phase}.{serial}", "NATS subject template, {measurement} and {<tag>} are replaced, leaving out tokens with tags a reading doesn't have")
+	natsCredsPtr   = flag.String("nats-creds", "", "NATS credentials file")
+	natsStreamPtr  = flag.String("nats-stream", "", "JetStream stream to persist readings in (default plain NATS publish)")
+)
+
+const natsTimeout = time.Second * 10
+
+// natsClient is a NATS connection, with its JetStream context for -nats-stream
+type natsClient struct {
+	nc *nats.Conn
+	js nats.JetStreamContext
+}
+
+var natsConn = newOutputConn(connectNATS, func(c natsClient) {
+	c.nc.Close()
+})
+
+func connectNATS(ctx context.Context) (natsClient, error) {
+	opts := []nats.Option{nats.Name("influxEnvoyStats"), nats.Timeout(natsTimeout)}
+	if *natsCredsPtr != "" {
+		opts = append(opts, nats.UserCredentials(*natsCredsPtr))
+	}
+	nc, err := nats.Connect(*natsURLPtr, opts...)
+	if err != nil {
+		return natsClient{}, err
+	}
+	c := natsClient{nc: nc}
+	if *natsStreamPtr != "" {
+		if c.js, err = nc.JetStream(nats.MaxWait(natsTimeout)); err == nil {
+			err = natsStream(c.js)
+		}
+		if err != nil {
+			nc.Close()
+			return natsClient{}, err
+		}
+	}
+	return c, nil
+}
+
+func publishNATS(ctx context.Context, pts []*client.Point) error {
+	return natsConn.use(ctx, func(c natsClient) error {
+		for _, pt := range pts {
+			payload, err := mqttJSON(pt)
+			if err != nil {
+				return err
+			}
+			subject := topicTemplate(*natsSubjectPtr, ".", pt)
+			if c.js != nil {
+				_, err = c.js.Publish(subject, payload)
+			} else {
+				err = c.nc.Publish(subject, payload)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return c.nc.FlushTimeout(withinDeadline(ctx, natsTimeout))
+	})
+}
+
+// natsStream creates the stream if it doesn't exist, with a wildcard subject
+// from the template's first placeholder on, as tokens with tags a reading
+// doesn't have are left out
+func natsStream(js nats.JetStreamContext) error {
+	if _, err := js.StreamInfo(*natsStreamPtr); err != nats.ErrStreamNotFound {
+		return err
+	}
+	tokens := strings.Split(*natsSubjectPtr, ".")
+	for i, t := range tokens {
+		if strings.Contains(t, "{") {
+			tokens = append(tokens[:i], ">")
+			break
+		}
+	}
+	_, err := js.AddStream(&nats.StreamConfig{
+		Name:     *natsStreamPtr,
+		Subjects: []string{strings.Join(tokens, ".")},
+		Storage:  nats.FileStorage,
+	})
+	return err
+}
@@ -0,0 +1,50 @@
+// openHAB output
+
+// Sets the state of openHAB items through its REST API, so rules can act on
+// live readings.  -openhab-items maps readings, given as type.field, to item
+// names; the items must exist, e.g. as Number:Power items for watts.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	openHABURLPtr   = flag.String("openhab-url", "http://localhost:8080", "openHAB URL")
+	openHABTokenPtr = flag.String("openhab-token", "", "openHAB API token (or OPENHAB_TOKEN / OPENHAB_TOKEN_FILE)")
+	openHABItemsPtr = flag.String("openhab-items",
+		"production.watts=Envoy_Production,total-consumption.watts=Envoy_Consumption,net-consumption.watts=Envoy_Net_Consumption,storage.percent_full=Envoy_Battery_SOC",
+		"openHAB items to update, as comma separated type.field=item mappings")
+)
+
+func writeOpenHAB(ctx context.Context, pts []*client.Point) error {
+	readings := readingsByType(pts)
+	items := mapping("openhab-items")
+	for _, reading := range sortedKeys(items) {
+		value, ok := readingValue(readings, reading)
+		if !ok {
+			continue
+		}
+		req, err := http.NewRequest(http.MethodPut,
+			strings.TrimRight(*openHABURLPtr, "/")+"/rest/items/"+url.PathEscape(items[reading])+"/state",
+			strings.NewReader(strconv.FormatFloat(value, 'f', -1, 64)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain")
+		if *openHABTokenPtr != "" {
+			req.Header.Set("Authorization", "Bearer "+*openHABTokenPtr)
+		}
+		if _, err := doOutputRequest(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Write ordering
+
+// Points are always written in timestamp order within a batch.  In strict mode
+// a point is also dropped if its series has already had a point written at or
+// after its time, as some downstream stores can't take out of order arrivals.
+
+package main
+
+import (
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Time of the last point written to each series, by InfluxDB address
+var (
+	seriesWritten   = map[string]map[string]time.Time{}
+	seriesWrittenMu sync.Mutex
+)
+
+// orderPoints gives a copy of points sorted by time, in strict mode leaving
+// out those no later than their series' last point written or earlier in the
+// batch.  Their times are recorded with markWritten once they're written, so a
+// failed write can be retried.
+func orderPoints(addr string, pts []*client.Point) []*client.Point {
+	sorted := append([]*client.Point(nil), pts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Time().Before(sorted[j].Time())
+	})
+	if *writeOrderPtr != "strict" {
+		return sorted
+	}
+
+	seriesWrittenMu.Lock()
+	defer seriesWrittenMu.Unlock()
+	written := seriesWritten[addr]
+	latest := map[string]time.Time{}
+	ordered := sorted[:0:0]
+	for _, pt := range sorted {
+		key := seriesKey(pt)
+		last, ok := latest[key]
+		if !ok {
+			last, ok = written[key]
+		}
+		if ok && !pt.Time().After(last) {
+			log.Printf("Dropping out of order point %s", pt)
+			continue
+		}
+		latest[key] = pt.Time()
+		ordered = append(ordered, pt)
+	}
+	return ordered
+}
+
+// markWritten records the times of points written to addr, for orderPoints
+func markWritten(addr string, pts []*client.Point) {
+	seriesWrittenMu.Lock()
+	defer seriesWrittenMu.Unlock()
+	written, ok := seriesWritten[addr]
+	if !ok {
+		written = map[string]time.Time{}
+		seriesWritten[addr] = written
+	}
+	for _, pt := range pts {
+		key := seriesKey(pt)
+		if last, ok := written[key]; !ok || pt.Time().After(last) {
+			written[key] = pt.Time()
+		}
+	}
+}
+
+func seriesKey(pt *client.Point) string {
+	tags := pt.Tags()
+	key := pt.Name()
+	for _, k := range sortedKeys(tags) {
+		key += "," + k + "=" + tags[k]
+	}
+	return key
+}
@@ -0,0 +1,75 @@
+// Output connections
+
+// Outputs which connect to a broker or database (MQTT, NATS, AMQP, Redis,
+// PostgreSQL, Kafka, SQLite and InfluxDB over UDP) keep their connection from
+// one write to the next, rather than connecting for every collection, as the
+// InfluxDB and HTTP outputs keep their clients.  The cloud outputs (BigQuery,
+// Timestream, Azure Data Explorer and S3) likewise keep their SDK's client or
+// credential, with the tokens it holds.  After a failed write the connection
+// is closed, so the next write (or retry) connects again.  They're closed on
+// stopping, and on reloading so that changed settings are used.
+
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// outputConn is an output's connection, made on first use
+type outputConn[C any] struct {
+	mu      sync.Mutex
+	conn    C
+	open    bool
+	connect func(context.Context) (C, error)
+	close   func(C)
+}
+
+var outputConns []interface{ reset() }
+
+func newOutputConn[C any](connect func(context.Context) (C, error), close func(C)) *outputConn[C] {
+	c := &outputConn[C]{connect: connect, close: close}
+	outputConns = append(outputConns, c)
+	return c
+}
+
+// use writes with the connection, connecting first if need be, and closes it
+// if the write fails
+func (c *outputConn[C]) use(ctx context.Context, write func(C) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.open {
+		conn, err := c.connect(ctx)
+		if err != nil {
+			return err
+		}
+		c.conn, c.open = conn, true
+	}
+	err := write(c.conn)
+	if err != nil {
+		c.closeConn()
+	}
+	return err
+}
+
+// reset closes the connection, so that it's made again when next used
+func (c *outputConn[C]) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeConn()
+}
+
+func (c *outputConn[C]) closeConn() {
+	if c.open {
+		c.close(c.conn)
+		var none C
+		c.conn, c.open = none, false
+	}
+}
+
+// closeOutputConns closes every output's connection
+func closeOutputConns() {
+	for _, c := range outputConns {
+		c.reset()
+	}
+}
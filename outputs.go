@@ -0,0 +1,582 @@
+// Outputs
+
+// Collected data fans out to each output selected with -output.  Each keeps its
+// own buffer of points it failed to write, which are retried with the next
+// write, and its own count of writes and failures, so one backend being down
+// doesn't stop readings reaching the others.  The buffer can be on disk
+// instead (see spool.go).  One that keeps failing is left alone for a while
+// (see breaker.go).  InfluxDB remains where events, reports and daily curves
+// are kept.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outputs by name, for -output
+var outputs = map[string]Output{
+	"adx":              outputFunc(writeADX),
+	"amqp":             outputFunc(publishAMQP),
+	"bigquery":         outputFunc(writeBigQuery),
+	"clickhouse":       outputFunc(writeClickHouse),
+	"csv":              outputFunc(writeCSV),
+	"domoticz":         outputFunc(writeDomoticz),
+	"emoncms":          outputFunc(writeEmonCMS),
+	"exec":             outputFunc(writeExec),
+	"influx":           outputFunc(writePoints),
+	"influx-secondary": outputFunc(writeSecondaryInflux),
+	"jsonl":            outputFunc(writeJSONLines),
+	"kafka":            outputFunc(writeKafka),
+	"lineprotocol":     outputFunc(writeLineProtocol),
+	"mqtt":             outputFunc(publishMQTT),
+	"nats":             outputFunc(publishNATS),
+	"openhab":          outputFunc(writeOpenHAB),
+	"postgres":         outputFunc(writePostgres),
+	"pvoutput":         outputFunc(writePVOutput),
+	"questdb":          outputFunc(writeQuestDB),
+	"redis":            outputFunc(writeRedisStreams),
+	"s3":               outputFunc(writeS3Parquet),
+	"socket":           outputFunc(writeSocket),
+	"sparkplug":        outputFunc(publishSparkplug),
+	"sqlite":           outputFunc(writeSQLite),
+	"statsd":           outputFunc(writeStatsD),
+	"timestream":       outputFunc(writeTimestream),
+	"vm":               outputFunc(writeVictoriaMetrics),
+	"webhook":          outputFunc(writeWebhooks),
+}
+
+// Where to print readings as they're collected, which is stderr if an output
+// writes to stdout
+var console io.Writer = os.Stdout
+
+// Output is somewhere readings are written
+type Output interface {
+	Write(ctx context.Context, pts []*client.Point) error
+}
+
+// outputFunc adapts a function writing points to Output
+type outputFunc func(context.Context, []*client.Point) error
+
+func (f outputFunc) Write(ctx context.Context, pts []*client.Point) error {
+	return f(ctx, pts)
+}
+
+// bufferedOutput retries an output's failed writes and keeps its statistics
+type bufferedOutput struct {
+	name      string
+	output    Output
+	breaker   *circuitBreaker
+	spool     *spool     // Instead of buffer, with -spool-dir
+	mu        sync.Mutex // Guards the rest, for status while writing
+	buffer    []*client.Point
+	writes    int
+	failures  int
+	dropped   int
+	lastWrite time.Time
+	took      time.Duration // How long the last successful write took
+	lastErr   error
+}
+
+var enabledOutputs = []*bufferedOutput{}
+
+// setupOutputs enables the outputs -output names, keeping those already
+// enabled (with their buffered points) on reload
+func setupOutputs() error {
+	enabled := map[string]*bufferedOutput{}
+	for _, o := range enabledOutputs {
+		enabled[o.name] = o
+	}
+	list := []*bufferedOutput{}
+	for _, name := range strings.Split(*outputPtr, ",") {
+		name = strings.TrimSpace(name)
+		output, ok := outputs[name]
+		if !ok {
+			return configError("unknown output %q", name)
+		}
+		if name == "lineprotocol" || name == "jsonl" && *jsonLinesPathPtr == "-" {
+			console = os.Stderr
+		}
+		if o, ok := enabled[name]; ok {
+			list = append(list, o)
+			continue
+		}
+		o := &bufferedOutput{
+			name:    name,
+			output:  output,
+			breaker: newCircuitBreaker("Output " + name),
+		}
+		if *spoolDirPtr != "" && !*dryRunPtr {
+			var err error
+			if o.spool, err = openSpool(name); err != nil {
+				return fmt.Errorf("output %s: %w", name, err)
+			}
+		}
+		list = append(list, o)
+	}
+	for _, setup := range []func() error{setupMappings, setupTimestamps, setupPrecision, setupBounds, setupTariffs, setupCarbon, setupAPIKeys} {
+		if err := setup(); err != nil {
+			return err
+		}
+	}
+	enabledOutputs = list
+	return nil
+}
+
+// writeCollected prepares points collected and writes them, or batches them
+// to write later
+func writeCollected(ctx context.Context, e busEvent) error {
+	pts, err := preparePoints(e.Points, e.Time)
+	if err != nil || len(pts) == 0 {
+		return err
+	}
+	return batchPoints(ctx, pts)
+}
+
+// preparePoints readies points collected at a time for writing, as the
+// settings say, dropping those which shouldn't be written
+func preparePoints(pts []*client.Point, collected time.Time) ([]*client.Point, error) {
+	pts, err := checkPoints(pts, collected)
+	if err != nil {
+		return nil, err
+	}
+	return dedupePoints(pts), nil
+}
+
+// checkPoints readies points as preparePoints does, without dropping those
+// already collected, for serving the latest readings (see prometheus.go)
+func checkPoints(pts []*client.Point, collected time.Time) ([]*client.Point, error) {
+	pts, err := applyTimestamps(pts, collected)
+	for _, step := range []func([]*client.Point) ([]*client.Point, error){selectFields, roundFields, tagVersion, checkBounds} {
+		if err != nil {
+			break
+		}
+		pts, err = step(pts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("preparing points: %w", err)
+	}
+	return pts, nil
+}
+
+// writeOutputs writes points to every output at once, failing if any of them
+// couldn't be written after retrying
+func writeOutputs(ctx context.Context, pts []*client.Point) error {
+	converted, err := schemaPoints(ctx, pts)
+	if err != nil {
+		return err
+	}
+	renamed, err := renamePoints(converted)
+	if err != nil {
+		return err
+	}
+	// pointsFor gives the points to write to an output
+	pointsFor := func(o *bufferedOutput) []*client.Point {
+		if originalNameOutputs[o.name] {
+			return pts
+		}
+		return renamed
+	}
+	if *dryRunPtr {
+		for _, o := range enabledOutputs {
+			printDryRun([]string{o.name}, pointsFor(o))
+		}
+		return nil
+	}
+	errs := make([]error, len(enabledOutputs))
+	var wg sync.WaitGroup
+	for i, o := range enabledOutputs {
+		// One at a time, so only one output's copy of the points is in memory
+		if *lowMemoryPtr {
+			errs[i] = o.Write(ctx, pointsFor(o))
+			continue
+		}
+		wg.Add(1)
+		go func(i int, o *bufferedOutput) {
+			defer wg.Done()
+			errs[i] = o.Write(ctx, pointsFor(o))
+		}(i, o)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for i, o := range enabledOutputs {
+		if errs[i] != nil {
+			failed = append(failed, o.name)
+		}
+	}
+	if len(failed) > 0 {
+		return exitError{exitWriteFailed, fmt.Errorf("writing to %s failed", strings.Join(failed, ", "))}
+	}
+	return nil
+}
+
+// flushOutputs retries writing each output's buffered points, e.g. when
+// stopping, returning how many still couldn't be written (and aren't spooled),
+// and closes the outputs' connections
+func flushOutputs(ctx context.Context) int {
+	defer closeOutputConns()
+	flushBatch(ctx)
+	unwritten := 0
+	for _, o := range enabledOutputs {
+		if buffered := o.status().Buffered; buffered > 0 {
+			log.Printf("Flushing %d points to %s", buffered, o.name)
+			if o.Write(ctx, nil) != nil && o.spool == nil {
+				unwritten += o.status().Buffered
+			}
+		}
+	}
+	return unwritten
+}
+
+func (o *bufferedOutput) Write(ctx context.Context, pts []*client.Point) error {
+	if o.spool != nil {
+		err := o.writeSpooled(ctx, pts)
+		var spoolErr spoolError
+		if errors.As(err, &spoolErr) {
+			o.mu.Lock()
+			o.lastErr = err
+			o.mu.Unlock()
+			log.Printf(journalPriority(3, "Output %s: %v"), o.name, err)
+		}
+		return err
+	}
+
+	o.mu.Lock()
+	o.buffer = append(o.buffer, pts...)
+	if excess := len(o.buffer) - *outputBufferPtr; excess > 0 {
+		o.buffer = o.buffer[excess:]
+		o.dropped += excess
+		log.Printf(journalPriority(4, "Output %s buffer full, dropped %d points"), o.name, excess)
+	}
+	buffer := o.buffer
+	o.mu.Unlock()
+
+	err := o.attempt(ctx, buffer)
+	if err == nil {
+		o.mu.Lock()
+		o.buffer = nil
+		o.mu.Unlock()
+	} else {
+		log.Printf(journalPriority(3, "Output %s: %v, %d points buffered"), o.name, err, len(buffer))
+	}
+	return err
+}
+
+// writeSpooled writes any spooled points in order before pts, spooling pts if
+// they can't be written yet
+func (o *bufferedOutput) writeSpooled(ctx context.Context, pts []*client.Point) error {
+	if o.spool.len() > 0 && len(pts) > 0 {
+		if err := o.spoolPoints(pts); err != nil {
+			return err
+		}
+		pts = nil
+	}
+	if len(pts) > 0 {
+		if err := o.attempt(ctx, pts); err != nil {
+			if spoolErr := o.spoolPoints(pts); spoolErr != nil {
+				return spoolErr
+			}
+			log.Printf(journalPriority(3, "Output %s: %v, %d points spooled"), o.name, err, o.spool.len())
+			return err
+		}
+	}
+	for o.spool.len() > 0 {
+		batch, err := o.spool.peek(*outputBufferPtr)
+		if err != nil {
+			return spoolError{err}
+		}
+		if err := o.attempt(ctx, batch); err != nil {
+			log.Printf(journalPriority(3, "Output %s: %v, %d points spooled"), o.name, err, o.spool.len())
+			return err
+		}
+		if err := o.spool.remove(len(batch)); err != nil {
+			return spoolError{err}
+		}
+		log.Printf("Output %s: replayed %d spooled points, %d left", o.name, len(batch), o.spool.len())
+	}
+	return nil
+}
+
+// spoolError is a failure of the spool itself, rather than the output
+type spoolError struct {
+	error
+}
+
+func (e spoolError) Unwrap() error {
+	return e.error
+}
+
+func (o *bufferedOutput) spoolPoints(pts []*client.Point) error {
+	dropped, err := o.spool.append(pts)
+	if err != nil {
+		return spoolError{err}
+	}
+	if dropped > 0 {
+		o.mu.Lock()
+		o.dropped += dropped
+		o.mu.Unlock()
+		log.Printf(journalPriority(4, "Output %s spool full, dropped %d points"), o.name, dropped)
+	}
+	return nil
+}
+
+// attempt writes points unless the output's circuit breaker is open
+func (o *bufferedOutput) attempt(ctx context.Context, pts []*client.Point) error {
+	err := o.breaker.allow()
+	if err == nil {
+		err = o.retry(ctx, pts)
+		o.breaker.record(err)
+	}
+	o.mu.Lock()
+	o.lastErr = err
+	o.mu.Unlock()
+	return err
+}
+
+// retry writes points, retrying -output-retries times
+func (o *bufferedOutput) retry(ctx context.Context, pts []*client.Point) error {
+	var err error
+	wait := *outputRetryWaitPtr
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err = o.output.Write(ctx, pts)
+		o.mu.Lock()
+		if err == nil {
+			o.writes++
+			o.lastWrite = time.Now()
+			o.took = o.lastWrite.Sub(start)
+			o.mu.Unlock()
+			return nil
+		}
+		o.failures++
+		o.mu.Unlock()
+		if attempt == *outputRetriesPtr || ctx.Err() != nil {
+			break
+		}
+		log.Printf("Output %s: %v, retrying in %s", o.name, err, wait)
+		if !sleepUntil(ctx, time.Now().Add(wait)) {
+			break
+		}
+		wait *= 2
+	}
+	return err
+}
+
+// lastTook is how long the output's last successful write took
+func (o *bufferedOutput) lastTook() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.took
+}
+
+// outputStatus is an output's statistics, as reported on /status
+type outputStatus struct {
+	Writes    int       `json:"writes"`
+	Failures  int       `json:"failures"`
+	Dropped   int       `json:"dropped"`
+	Buffered  int       `json:"buffered"`
+	LastWrite time.Time `json:"last_write"`
+	LastError string    `json:"last_error,omitempty"`
+	Breaker   string    `json:"breaker"`
+}
+
+func (o *bufferedOutput) status() outputStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status := outputStatus{
+		Writes:    o.writes,
+		Failures:  o.failures,
+		Dropped:   o.dropped,
+		Buffered:  len(o.buffer),
+		LastWrite: o.lastWrite,
+		Breaker:   string(o.breaker.current()),
+	}
+	if o.spool != nil {
+		status.Buffered = o.spool.len()
+	}
+	if o.lastErr != nil {
+		status.LastError = redactSecrets(o.lastErr.Error())
+	}
+	return status
+}
+
+// The client for outputs which write over HTTP, kept for reusing connections
+var (
+	outputClient     *http.Client
+	outputClientOnce sync.Once
+)
+
+// doOutputRequest makes the request of an output which writes over HTTP,
+// failing on anything but a 2xx response
+func doOutputRequest(ctx context.Context, req *http.Request) ([]byte, error) {
+	outputClientOnce.Do(func() {
+		outputClient = &http.Client{
+			Timeout: time.Second * 20,
+			Transport: &http.Transport{
+				Proxy: proxyFunc(*dbProxyPtr),
+			},
+		}
+	})
+	resp, err := outputClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Redacted(), err)
+	}
+	if resp.StatusCode/100 != 2 {
+		msg := strings.TrimSpace(string(body))
+		if len(msg) > 1024 {
+			msg = msg[:1024]
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, msg)
+	}
+	return body, nil
+}
+
+func gzipBody(body []byte) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(body)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compressing: %w", err)
+	}
+	return &buf, nil
+}
+
+// numericValue returns a field value as a float, for outputs which only take numbers
+func numericValue(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case float64:
+		return value, true
+	case int64:
+		return float64(value), true
+	case bool:
+		if value {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// pointTemplate replaces {measurement} and {<tag>} in a template with the
+// point's measurement and tag values
+func pointTemplate(template string, pt *client.Point) string {
+	return nameTemplate(template, pt.Name(), pt.Tags())
+}
+
+// topicTemplate is pointTemplate for MQTT topics, NATS subjects and AMQP
+// routing keys, made of levels split by sep, leaving out the levels with a tag
+// the point doesn't have, e.g. {type} for inverters or {serial} for readings
+func topicTemplate(template, sep string, pt *client.Point) string {
+	tags := pt.Tags()
+	levels := []string{}
+	for _, level := range strings.Split(template, sep) {
+		if hasTemplateTags(level, tags) {
+			levels = append(levels, nameTemplate(level, pt.Name(), tags))
+		}
+	}
+	return strings.Join(levels, sep)
+}
+
+// hasTemplateTags is whether tags has each {<tag>} in a template
+func hasTemplateTags(template string, tags map[string]string) bool {
+	for rest := template; ; {
+		start := strings.Index(rest, "{")
+		end := strings.Index(rest, "}")
+		if start < 0 || end < start {
+			return true
+		}
+		if name := rest[start+1 : end]; name != "measurement" && tags[name] == "" {
+			return false
+		}
+		rest = rest[end+1:]
+	}
+}
+
+func nameTemplate(template, measurement string, tags map[string]string) string {
+	s := strings.Replace(template, "{measurement}", measurement, -1)
+	for k, v := range tags {
+		s = strings.Replace(s, "{"+k+"}", v, -1)
+	}
+	return s
+}
+
+// readingsByType indexes readings by their type tag, e.g. "production"
+func readingsByType(pts []*client.Point) map[string]*client.Point {
+	readings := map[string]*client.Point{}
+	for _, pt := range pts {
+		if pt.Name() == *measurementNamePtr {
+			readings[pt.Tags()["type"]] = pt
+		}
+	}
+	return readings
+}
+
+// readingValue looks up a numeric field given as type.field, e.g. production.watts
+func readingValue(readings map[string]*client.Point, name string) (float64, bool) {
+	parts := strings.SplitN(name, ".", 2)
+	pt, ok := readings[parts[0]]
+	if !ok || len(parts) != 2 {
+		return 0, false
+	}
+	fields, err := pt.Fields()
+	if err != nil {
+		return 0, false
+	}
+	return numericValue(fields[parts[1]])
+}
+
+// mappingList parses a flag's comma separated list of key=value mappings
+func mappingList(name, list string) (map[string]string, error) {
+	mappings := map[string]string{}
+	if list == "" {
+		return mappings, nil
+	}
+	for _, mapping := range strings.Split(list, ",") {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 {
+			return nil, configError("invalid -%s mapping %q", name, mapping)
+		}
+		mappings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return mappings, nil
+}
+
+// setupMappings checks the settings which are lists of mappings, so they can
+// be read with mapping from then on
+func setupMappings() error {
+	for _, name := range mappingSettings {
+		if _, err := mappingList(name, flag.Lookup(name).Value.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mapping reads a setting checked by setupMappings
+func mapping(name string) map[string]string {
+	mappings, _ := mappingList(name, flag.Lookup(name).Value.String())
+	return mappings
+}
@@ -0,0 +1,28 @@
+// Per-phase readings
+
+// Split phase and three phase meters report each phase (line) as well as the
+// total.  With phases in -collect, each is written to the phases measurement,
+// tagged with its type and phase, l1, l2 or l3, e.g.
+//   phases,phase=l1,type=production watts=1490.2,wh_today=6659.1,wh_lifetime=2184119.6
+// Lifetime counter resets are only followed for the totals.
+
+package main
+
+import (
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"time"
+)
+
+const phasesMeasurement = "phases"
+
+// phasePoint builds the point stored for one phase of a reading
+func phasePoint(reading Eim, line int, phase Eim) (*client.Point, error) {
+	tags := map[string]string{
+		"type":  reading.MeasurementType,
+		"phase": fmt.Sprintf("l%d", line+1),
+	}
+	fields := readingFields(phase)
+	fields["wh_lifetime"] = phase.WhLifetime
+	return newPoint(phasesMeasurement, tags, fields, time.Unix(reading.ReadingTime, 0))
+}
@@ -0,0 +1,165 @@
+// TimescaleDB / PostgreSQL output
+
+// Each measurement is written to a table of the same name (e.g. readings), or
+// inverter_readings for the inverters, with a time column, a text column per
+// tag and a column per field.  Tables and any new columns are created as
+// needed, and made TimescaleDB hypertables when the timescaledb extension has
+// been created in the database.  The connection keeps the columns each table
+// is known to have, so only a new one needs the schema changing.  Points are
+// inserted in batches with COPY.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+var postgresUrlPtr = flag.String("pg-url", "postgres://localhost/solar", "PostgreSQL/TimescaleDB connection URL (or PG_URL / PG_URL_FILE)")
+
+// Tables written to other than by their measurement's name
+var postgresTables = map[string]string{
+	invertersMeasurement: "inverter_readings",
+}
+
+// postgresSession is the PostgreSQL output's connection, with whether the
+// database has TimescaleDB and the columns of each table written to so far
+type postgresSession struct {
+	conn      *pgx.Conn
+	timescale bool
+	columns   map[string]map[string]bool
+}
+
+var postgresConn = newOutputConn(func(ctx context.Context) (*postgresSession, error) {
+	conn, err := pgx.Connect(ctx, *postgresUrlPtr)
+	if err != nil {
+		return nil, err
+	}
+	s := &postgresSession{conn: conn, columns: map[string]map[string]bool{}}
+	err = conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&s.timescale)
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+	return s, nil
+}, func(s *postgresSession) {
+	s.conn.Close(context.Background())
+})
+
+func writePostgres(ctx context.Context, pts []*client.Point) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	return postgresConn.use(ctx, func(s *postgresSession) error {
+		tables := map[string][]*client.Point{}
+		for _, pt := range pts {
+			table := pt.Name()
+			if name, ok := postgresTables[table]; ok {
+				table = name
+			}
+			tables[table] = append(tables[table], pt)
+		}
+		for _, table := range sortedKeys(tables) {
+			if err := s.copy(ctx, table, tables[table]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *postgresSession) copy(ctx context.Context, table string, pts []*client.Point) error {
+	// Every tag and field seen in the batch is a column
+	columnTypes := map[string]string{}
+	rows := make([]map[string]interface{}, len(pts))
+	for i, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		for k, v := range pt.Tags() {
+			columnTypes[k] = "text"
+			fields[k] = v
+		}
+		for k, v := range fields {
+			if _, ok := columnTypes[k]; !ok {
+				columnTypes[k] = postgresType(v)
+			}
+		}
+		fields["time"] = pt.Time()
+		rows[i] = fields
+	}
+
+	columns := sortedKeys(columnTypes)
+	if err := s.migrate(ctx, table, columns, columnTypes); err != nil {
+		return err
+	}
+
+	columns = append([]string{"time"}, columns...)
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = make([]interface{}, len(columns))
+		for j, column := range columns {
+			values[i][j] = row[column]
+		}
+	}
+	_, err := s.conn.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(values))
+	return err
+}
+
+// migrate creates a table the first time it's written to on the connection,
+// and adds any columns it doesn't have yet
+func (s *postgresSession) migrate(ctx context.Context, table string, columns []string, columnTypes map[string]string) error {
+	name := pgx.Identifier{table}.Sanitize()
+	known, ok := s.columns[table]
+	if !ok {
+		_, err := s.conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+name+" (time timestamptz NOT NULL)")
+		if err != nil {
+			return err
+		}
+		if s.timescale {
+			_, err = s.conn.Exec(ctx, "SELECT create_hypertable($1, 'time', if_not_exists => TRUE, migrate_data => TRUE)", table)
+			if err != nil {
+				return err
+			}
+		}
+		rows, err := s.conn.Query(ctx, "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1", table)
+		if err != nil {
+			return err
+		}
+		existing, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+		known = map[string]bool{}
+		for _, column := range existing {
+			known[column] = true
+		}
+		s.columns[table] = known
+	}
+	for _, column := range columns {
+		if known[column] {
+			continue
+		}
+		_, err := s.conn.Exec(ctx, "ALTER TABLE "+name+" ADD COLUMN IF NOT EXISTS "+pgx.Identifier{column}.Sanitize()+" "+columnTypes[column])
+		if err != nil {
+			return err
+		}
+		known[column] = true
+	}
+	return nil
+}
+
+func postgresType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "double precision"
+	case int64:
+		return "bigint"
+	case bool:
+		return "boolean"
+	}
+	return "text"
+}
@@ -0,0 +1,47 @@
+// Profiling
+
+// With -pprof-listen, net/http/pprof profiles and expvar runtime metrics are
+// served on their own address, so a long running daemon can be profiled where
+// it runs, e.g. with `go tool pprof http://pi:6060/debug/pprof/heap`.  With
+// -api-keys they need a key with the admin scope (see apiKeys.go).
+
+package main
+
+import (
+	"expvar"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+var pprofListenPtr = flag.String("pprof-listen", "", "Serve pprof profiles on /debug/pprof/ and runtime metrics on /debug/vars at this address, e.g. localhost:6060")
+
+// servePprof starts serving profiles and runtime metrics in the background
+func servePprof() error {
+	if *pprofListenPtr == "" {
+		return nil
+	}
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	ln, err := net.Listen("tcp", *pprofListenPtr)
+	if err != nil {
+		return fmt.Errorf("serving pprof: %w", err)
+	}
+	log.Printf("Serving pprof on %s/debug/pprof/", *pprofListenPtr)
+	go http.Serve(ln, requireScope("admin", mux))
+	return nil
+}
@@ -0,0 +1,64 @@
+// Timestamp precision and rounding
+
+// Points are written to InfluxDB with second timestamps, as the Envoy's own
+// reading times are whole seconds; -precision ms keeps the milliseconds of
+// collector timestamps (see timestamps.go).  Some Envoy readings carry sub-watt
+// noise in many decimal places, which compresses badly, so -round rounds every
+// float field to a number of decimal places, e.g. -round 1.
+
+package main
+
+import (
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"math"
+	"time"
+)
+
+var (
+	precisionPtr = flag.String("precision", "s", "Timestamp precision to write to InfluxDB: s or ms")
+	roundPtr     = flag.Int("round", -1, "Round float fields to this many decimal places (-1 to leave them as they are)")
+)
+
+func setupPrecision() error {
+	if *precisionPtr != "s" && *precisionPtr != "ms" {
+		return configError("unknown -precision %q, use s or ms", *precisionPtr)
+	}
+	if *roundPtr < -1 {
+		return configError("-round %d is negative, give decimal places, or -1 not to round", *roundPtr)
+	}
+	return nil
+}
+
+// precision is -precision as a duration
+func precision() time.Duration {
+	if *precisionPtr == "ms" {
+		return time.Millisecond
+	}
+	return time.Second
+}
+
+// roundFields rounds the float fields of points to -round decimal places
+func roundFields(pts []*client.Point) ([]*client.Point, error) {
+	if *roundPtr < 0 {
+		return pts, nil
+	}
+	scale := math.Pow10(*roundPtr)
+	rounded := make([]*client.Point, 0, len(pts))
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fields {
+			if f, ok := v.(float64); ok {
+				fields[k] = math.Round(f*scale) / scale
+			}
+		}
+		if pt, err = newPoint(pt.Name(), pt.Tags(), fields, pt.Time()); err != nil {
+			return nil, err
+		}
+		rounded = append(rounded, pt)
+	}
+	return rounded, nil
+}
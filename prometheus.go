@@ -0,0 +1,145 @@
+// Prometheus exporter mode
+
+// Serves the readings as gauges on /metrics, collecting from the Envoy on each
+// scrape.  Every numeric field of every point becomes a gauge named
+// envoy_<measurement>_<field>, labelled with the point's tags, e.g.
+//   envoy_readings_watts{type="production"} 2977.73
+// With inverters in -collect, each inverter's readings are labelled with its
+// serial number, e.g.
+//   envoy_inverters_watts{serial="121812345678"} 243
+// and batteries with their kind, e.g. storage="acb".  The readings are checked
+// as for writing, against -bounds and so on (see outputs.go), but served
+// whether or not they've changed since the last scrape, as a gauge would go
+// stale otherwise.  Should two points of a scrape still be of the same series,
+// only the latest is served.  A scrape is cut short at -cycle-timeout.  With
+// -api-keys, scraping needs a key with the read scope (see apiKeys.go).
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+func servePrometheus() error {
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", requireScope("read", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var pts []*client.Point
+		err := withCycleDeadline(func(ctx context.Context) error {
+			collected := time.Now()
+			scraped, err := collectScrape(ctx)
+			if err != nil {
+				return err
+			}
+			if *prometheusInfluxPtr {
+				if err := publish(ctx, busEvent{Kind: dataReadyEvent, Time: collected, Points: scraped}); err != nil {
+					return err
+				}
+			}
+			pts, err = checkPoints(scraped, collected)
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := writePrometheus(w, pts); err != nil {
+			log.Printf("Writing Prometheus metrics failed: %v", err)
+		}
+	})))
+
+	log.Printf("Serving Prometheus metrics on %s/metrics", *prometheusListenPtr)
+	return http.ListenAndServe(*prometheusListenPtr, mux)
+}
+
+// collectScrape collects everything -collect names for a scrape, failing only
+// if nothing could be collected
+func collectScrape(ctx context.Context) ([]*client.Point, error) {
+	list := collectors()
+	if len(list) == 0 {
+		return nil, configError("nothing to collect, see -collect")
+	}
+
+	pts := []*client.Point{}
+	failures := []error{}
+	for _, c := range list {
+		collected, err := c.collect(ctx)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("collecting %s: %w", c.name, err))
+			continue
+		}
+		pts = append(pts, collected...)
+	}
+	if len(failures) == len(list) {
+		return nil, errors.Join(failures...)
+	}
+	for _, err := range failures {
+		log.Printf(journalPriority(4, "Scrape: %v"), err)
+	}
+	return pts, nil
+}
+
+var prometheusNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writePrometheus(w io.Writer, pts []*client.Point) error {
+	samples := map[string][]string{}
+	for _, pt := range latestPoints(pts) {
+		tags := pt.Tags()
+		labels := []string{}
+		for _, k := range sortedKeys(tags) {
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, prometheusNameRegexp.ReplaceAllString(k, "_"), prometheusLabelEscaper.Replace(tags[k])))
+		}
+
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		for field, value := range fields {
+			v, ok := numericValue(value)
+			if !ok {
+				continue
+			}
+			name := prometheusNameRegexp.ReplaceAllString("envoy_"+pt.Name()+"_"+field, "_")
+			samples[name] = append(samples[name], fmt.Sprintf("%s{%s} %g", name, strings.Join(labels, ","), v))
+		}
+	}
+
+	for _, name := range sortedKeys(samples) {
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s\n", name, strings.Join(samples[name], "\n"))
+	}
+	return nil
+}
+
+// latestPoints keeps the latest point of each series, in the order collected
+func latestPoints(pts []*client.Point) []*client.Point {
+	latest := map[string]int{}
+	for i, pt := range pts {
+		key := seriesKey(pt)
+		if j, ok := latest[key]; !ok || !pt.Time().Before(pts[j].Time()) {
+			latest[key] = i
+		}
+	}
+	kept := make([]*client.Point, 0, len(latest))
+	for i, pt := range pts {
+		if latest[seriesKey(pt)] == i {
+			kept = append(kept, pt)
+		}
+	}
+	return kept
+}
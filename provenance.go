@@ -0,0 +1,59 @@
+// Where settings come from
+
+// Settings can come from the command line, secrets in the environment or Vault,
+// the -config file, or be set by another setting, e.g. -low-memory, or left as
+// the default.  -print-config prints every setting with where its value came
+// from, secrets redacted, to find out why it isn't what was expected.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var printConfigPtr = flag.Bool("print-config", false, "Print every setting, with where it came from, and exit")
+
+// Flags set from other settings, with what set them
+var derivedFlags = map[string]string{}
+
+// deriveFlag sets a flag on behalf of another setting or command
+func deriveFlag(name, value, from string) error {
+	if err := flag.Set(name, value); err != nil {
+		return err
+	}
+	derivedFlags[name] = from
+	return nil
+}
+
+// flagSource describes where a flag's value came from
+func flagSource(name string) string {
+	source := "default"
+	switch {
+	case derivedFlags[name] != "":
+		source = derivedFlags[name]
+	case explicitFlags()[name]:
+		source = "command line"
+	case envFlags[name] != "":
+		source = "environment " + envFlags[name]
+	case vaultSecretFlags[name]:
+		source = "Vault"
+	case configFileFlags[name]:
+		source = *configPtr
+	}
+	if ref, ok := awsSecretRefs[name]; ok {
+		source += ", " + ref
+	}
+	return source
+}
+
+// getConfig describes the effective configuration, with where each setting
+// came from and secrets redacted
+func getConfig() string {
+	var config strings.Builder
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(&config, "  %s=%s  (%s)\n", f.Name, f.Value, flagSource(f.Name))
+	})
+	return redactSecrets(config.String())
+}
@@ -0,0 +1,84 @@
+// PVOutput.org output
+
+// Posts a status of today's production and consumption energy and the current
+// power to PVOutput's Add Status service.  PVOutput only keeps one status per
+// system status interval (5 minutes by default) and limits requests per hour, so
+// a status is only posted once -pvoutput-interval has passed since the last one,
+// which is remembered in -pvoutput-state.  Donation-mode extended values v7-v12
+// can be mapped to any reading, e.g. -pvoutput-extended v7=storage.percent_full
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	pvOutputKeyPtr      = flag.String("pvoutput-key", "", "PVOutput API key (or PVOUTPUT_KEY / PVOUTPUT_KEY_FILE)")
+	pvOutputSystemPtr   = flag.String("pvoutput-system", "", "PVOutput system ID")
+	pvOutputIntervalPtr = flag.Duration("pvoutput-interval", time.Minute*5, "Minimum time between PVOutput statuses, the system's status interval")
+	pvOutputStatePtr    = flag.String("pvoutput-state", "envoy-pvoutput-state", "File remembering when a PVOutput status was last posted")
+	pvOutputExtendedPtr = flag.String("pvoutput-extended", "", "Donation mode extended values, e.g. v7=storage.percent_full,v8=net-consumption.watts")
+)
+
+const pvOutputURL = "https://pvoutput.org/service/r2/addstatus.jsp"
+
+func writePVOutput(ctx context.Context, pts []*client.Point) error {
+	readings := readingsByType(pts)
+	production, ok := readings["production"]
+	if !ok {
+		log.Print("No production reading for PVOutput")
+		return nil
+	}
+	t := production.Time().Local()
+	if last, err := ioutil.ReadFile(*pvOutputStatePtr); err == nil {
+		lastUnix, err := strconv.ParseInt(strings.TrimSpace(string(last)), 10, 64)
+		if err == nil && t.Sub(time.Unix(lastUnix, 0)) < *pvOutputIntervalPtr {
+			return nil
+		}
+	}
+
+	params := url.Values{
+		"d": {t.Format("20060102")},
+		"t": {t.Format("15:04")},
+	}
+	values := map[string]string{
+		"v1": "production.wh_today",
+		"v2": "production.watts",
+		"v3": "total-consumption.wh_today",
+		"v4": "total-consumption.watts",
+	}
+	for param, reading := range mapping("pvoutput-extended") {
+		values[param] = reading
+	}
+	for _, param := range sortedKeys(values) {
+		if value, ok := readingValue(readings, values[param]); ok {
+			// PVOutput doesn't take negative generation, e.g. at night
+			if param == "v2" && value < 0 {
+				value = 0
+			}
+			params.Set(param, strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, pvOutputURL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Pvoutput-Apikey", *pvOutputKeyPtr)
+	req.Header.Set("X-Pvoutput-SystemId", *pvOutputSystemPtr)
+	if _, err := doOutputRequest(ctx, req); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(*pvOutputStatePtr, []byte(strconv.FormatInt(t.Unix(), 10)+"\n"), 0644)
+}
@@ -0,0 +1,77 @@
+// QuestDB output
+
+// Writes line protocol to QuestDB's InfluxDB line protocol TCP endpoint.  With
+// authentication enabled in QuestDB, give the key id and the private key (the
+// "d" value of the JWK), which is used to sign the server's challenge.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	questDBAddrPtr  = flag.String("questdb-addr", "localhost:9009", "QuestDB line protocol TCP address")
+	questDBKeyIdPtr = flag.String("questdb-key-id", "", "QuestDB authentication key id")
+	questDBKeyPtr   = flag.String("questdb-key", "", "QuestDB authentication private key, base64url (or QUESTDB_KEY / QUESTDB_KEY_FILE)")
+)
+
+func writeQuestDB(ctx context.Context, pts []*client.Point) error {
+	conn, err := (&net.Dialer{Timeout: time.Second * 10}).DialContext(ctx, "tcp", *questDBAddrPtr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(withinDeadline(ctx, time.Second*30))); err != nil {
+		return err
+	}
+
+	if *questDBKeyIdPtr != "" {
+		if err := questDBAuthenticate(conn); err != nil {
+			return err
+		}
+	}
+
+	return writeLines(conn, pts)
+}
+
+// questDBAuthenticate answers the server's challenge with an ECDSA P-256 signature
+func questDBAuthenticate(conn net.Conn) error {
+	_, err := fmt.Fprintf(conn, "%s\n", *questDBKeyIdPtr)
+	if err != nil {
+		return err
+	}
+	challenge, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	challenge = challenge[:len(challenge)-1]
+
+	d, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*questDBKeyPtr, "="))
+	if err != nil {
+		return err
+	}
+	key, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), d)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(challenge)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "%s\n", base64.StdEncoding.EncodeToString(signature))
+	return err
+}
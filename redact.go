@@ -0,0 +1,88 @@
+// Redaction of credentials from logs and errors
+
+// Errors (e.g. from a failed request) and the startup config can contain
+// passwords and tokens, either as secret settings or as user:pass@ in URLs.
+// Everything logged goes through redactSecrets.  Secrets resolved from Vault,
+// AWS or the environment are registered too, as they may be set on settings
+// which aren't secret, or (like VAULT_TOKEN) not be settings at all.  Secrets
+// are masked as they are, and as escaped in URLs.
+
+package main
+
+import (
+	"flag"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redacted = "****"
+
+var urlPasswordRegexp = regexp.MustCompile(`(://[^:/@\s]+:)[^@/\s]+@`)
+
+var (
+	resolvedSecretsMu sync.Mutex
+	resolvedSecrets   = map[string]bool{}
+)
+
+// redact masks each of secrets and any URL passwords in s
+func redact(s string, secrets []string) string {
+	forms := []string{}
+	for _, secret := range secrets {
+		if secret != "" {
+			forms = append(forms, secret, url.QueryEscape(secret), url.PathEscape(secret))
+		}
+	}
+	// Longest first, so a secret containing another is masked whole
+	sort.SliceStable(forms, func(i, j int) bool { return len(forms[i]) > len(forms[j]) })
+	for _, form := range forms {
+		s = strings.Replace(s, form, redacted, -1)
+	}
+	return urlPasswordRegexp.ReplaceAllString(s, "${1}"+redacted+"@")
+}
+
+// registerSecret has a secret value masked wherever it's logged
+func registerSecret(value string) {
+	if value == "" {
+		return
+	}
+	resolvedSecretsMu.Lock()
+	defer resolvedSecretsMu.Unlock()
+	resolvedSecrets[value] = true
+}
+
+// redactSecrets masks the configured secrets in s
+func redactSecrets(s string) string {
+	return redact(s, secretValues())
+}
+
+// secretValues returns the values of secret settings which aren't their
+// (public) defaults, and the registered secrets
+func secretValues() []string {
+	secrets := []string{}
+	for name := range secretEnvVars {
+		if f := flag.Lookup(name); f != nil && f.Value.String() != f.DefValue {
+			secrets = append(secrets, f.Value.String())
+		}
+	}
+	resolvedSecretsMu.Lock()
+	defer resolvedSecretsMu.Unlock()
+	for secret := range resolvedSecrets {
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
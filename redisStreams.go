@@ -0,0 +1,56 @@
+// Redis Streams output
+
+// Adds each point to a stream as an entry of its time, tags and fields, e.g.
+//   XADD envoy:readings MAXLEN ~ 10000 * time 1544843146 type production watts 2977.73
+// Streams are trimmed to roughly -redis-maxlen entries so they hold recent data
+// for dashboards and consumers to tail, not a full history.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+var (
+	redisURLPtr    = flag.String("redis-url", "redis://localhost:6379/0", "Redis URL, rediss:// for TLS (or REDIS_URL / REDIS_URL_FILE)")
+	redisStreamPtr = flag.String("redis-stream", "envoy:{measurement}", "Redis stream key template, {measurement} and {<tag>} are replaced")
+	redisMaxLenPtr = flag.Int64("redis-maxlen", 10000, "Approximate number of entries to trim each Redis stream to, 0 to keep everything")
+)
+
+const redisTimeout = time.Second * 10
+
+var redisConn = newOutputConn(func(context.Context) (*redis.Client, error) {
+	opts, err := redis.ParseURL(*redisURLPtr)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(opts), nil
+}, func(rdb *redis.Client) {
+	rdb.Close()
+})
+
+func writeRedisStreams(ctx context.Context, pts []*client.Point) error {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	return redisConn.use(ctx, func(rdb *redis.Client) error {
+		pipe := rdb.Pipeline()
+		for _, pt := range pts {
+			values, err := mqttPayload(pt)
+			if err != nil {
+				return err
+			}
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: pointTemplate(*redisStreamPtr, pt),
+				MaxLen: *redisMaxLenPtr,
+				Approx: true,
+				Values: values,
+			})
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,227 @@
+// Reloading the configuration
+
+// On SIGHUP a daemon reads its configuration again between collections,
+// without stopping: the -config file, and secrets from environment variables
+// (and their _FILE files), Vault and AWS, so that changed settings and rotated
+// passwords and tokens are picked up without a restart and a gap in the
+// readings.  Settings keep the precedence they have at startup, the command
+// line over secrets from the environment or Vault over the file, and those
+// taken out of the file go back to their defaults.  The interval or schedule,
+// what's collected, outputs and their credentials, and alert rules
+// (-anomaly-window, -bounds and the like) apply from the next collection.
+// Settings only used at startup, such as listen addresses, are kept as they
+// were until a restart.  If the new configuration isn't valid, it's logged and
+// the current one is kept.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Settings which only take effect on starting
+var restartSettings = []string{
+	"config", "env-prefix", "envoy-max-inflight", "envoy-proxy", "db-proxy", "interval-ensemble",
+	"interval-inventory", "interval-inverters", "interval-meters", "low-memory", "pprof-listen",
+	"schema", "spool-dir", "state-file", "status-listen",
+}
+
+// Held while settings change, for those reading them outside of collections
+var settingsMu sync.RWMutex
+
+func reloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				reloadConfig(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// reloadConfig reads the configuration again, keeping the current one if the
+// new one fails
+func reloadConfig(ctx context.Context) {
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	before := flagValues()
+	previous := enabledOutputs
+	sources := []map[string]bool{configFileFlags, vaultSecretFlags}
+	saved := []map[string]bool{copyMap(configFileFlags), copyMap(vaultSecretFlags)}
+	derived, refs := copyMap(derivedFlags), copyMap(awsSecretRefs)
+	if err := reloadSettings(ctx, before); err != nil {
+		log.Printf(journalPriority(3, "Reloading failed, keeping the current configuration: %v"), err)
+		for i, source := range sources {
+			restoreMap(source, saved[i])
+		}
+		restoreMap(derivedFlags, derived)
+		restoreMap(awsSecretRefs, refs)
+		if err := restoreSettings(before); err != nil {
+			log.Printf(journalPriority(3, "Restoring the configuration failed: %v"), err)
+		}
+		return
+	}
+
+	for _, o := range previous {
+		if !contains(outputNames(), o.name) {
+			if buffered := o.status().Buffered; buffered > 0 && o.Write(ctx, nil) != nil {
+				log.Printf(journalPriority(4, "Output %s removed with %d points unwritten"), o.name, buffered)
+			}
+		}
+	}
+	closeInfluxClients()
+	closeOutputConns()
+	changed := []string{}
+	for name, value := range flagValues() {
+		if value != before[name] {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		log.Print("Reloaded, nothing changed")
+		return
+	}
+	sort.Strings(changed)
+	log.Printf("Reloaded, changed %s", strings.Join(changed, ", "))
+}
+
+// restoreSettings sets the flags back to values they had, and sets up with
+// them again
+func restoreSettings(values map[string]string) error {
+	for name, value := range values {
+		if err := flag.Lookup(name).Value.Set(value); err != nil {
+			return err
+		}
+	}
+	for _, setup := range []func() error{setupSchedule, setupCollect, setupOutputs} {
+		if err := setup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reloadSettings sets the flags from the configuration file and secrets again,
+// and sets up with them
+func reloadSettings(ctx context.Context, before map[string]string) error {
+	if *configPtr != "" {
+		settings, err := readConfigFile(*configPtr)
+		if err != nil {
+			return err
+		}
+		if err := setFromConfigFile(settings); err != nil {
+			return err
+		}
+	}
+	if err := secretsFromEnv(); err != nil {
+		return err
+	}
+	for _, setup := range []func(context.Context) error{secretsFromVault, secretsFromAWS} {
+		if err := setup(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range restartSettings {
+		if value := flag.Lookup(name).Value.String(); value != before[name] {
+			log.Printf(journalPriority(4, "-%s changed, which needs a restart"), name)
+			if err := flag.Lookup(name).Value.Set(before[name]); err != nil {
+				return err
+			}
+		}
+	}
+	if !daemonMode() {
+		return configError("reloading can't stop the daemon, it needs -interval or -schedule")
+	}
+	for _, setup := range []func() error{setupSchedule, setupCollect, setupOutputs} {
+		if err := setup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setFromConfigFile sets the flags from the configuration file's settings, as
+// at startup, and those it no longer has back to their defaults
+func setFromConfigFile(settings map[string]string) error {
+	set := explicitFlags()
+	overridden := func(name string) bool {
+		return set[name] || envFlags[name] != "" || vaultSecretFlags[name]
+	}
+	for _, name := range sortedKeys(configFileFlags) {
+		if _, ok := settings[name]; !ok && !overridden(name) {
+			f := flag.Lookup(name)
+			if err := f.Value.Set(f.DefValue); err != nil {
+				return configError("-%s: %v", name, err)
+			}
+			delete(configFileFlags, name)
+			delete(awsSecretRefs, name)
+		}
+	}
+	for _, name := range sortedKeys(settings) {
+		if overridden(name) {
+			continue
+		}
+		if err := flag.Set(name, settings[name]); err != nil {
+			return configError("%s: %s: %v", *configPtr, name, err)
+		}
+		configFileFlags[name] = true
+		delete(derivedFlags, name)
+		// Resolved again if it's still in AWS
+		delete(awsSecretRefs, name)
+	}
+	return nil
+}
+
+func copyMap[V any](m map[string]V) map[string]V {
+	copied := make(map[string]V, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+// restoreMap makes m what it was when saved
+func restoreMap[V any](m, saved map[string]V) {
+	for k := range m {
+		delete(m, k)
+	}
+	for k, v := range saved {
+		m[k] = v
+	}
+}
+
+// flagValues returns every flag's value, by name
+func flagValues() map[string]string {
+	values := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+// outputNames are the outputs enabled
+func outputNames() []string {
+	names := []string{}
+	for _, o := range enabledOutputs {
+		names = append(names, o.name)
+	}
+	return names
+}
@@ -0,0 +1,225 @@
+// S3 / MinIO Parquet archive output
+
+// Readings are buffered in a local JSON lines file and, once the oldest is
+// -s3-flush old, written as Parquet files partitioned by site and date:
+//   s3://bucket/envoy/site=envoy.local/date=2019-01-31/1548892800-1548896400.parquet
+// with columns time, measurement, tags (map) and fields (map), so the archive
+// can be queried in place, e.g. with DuckDB:
+//   SELECT time, fields['watts'] FROM read_parquet('s3://bucket/envoy/*/*/*.parquet', hive_partitioning = true)
+//     WHERE tags['type'] = 'production'
+// Credentials come from the standard AWS credential chain, and the client is
+// kept from one upload to the next (see outputConns.go).  -s3-endpoint points
+// at S3 compatible storage such as MinIO instead of AWS.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/parquet-go/parquet-go"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	s3BucketPtr   = flag.String("s3-bucket", "", "S3 bucket to archive readings to as Parquet")
+	s3PrefixPtr   = flag.String("s3-prefix", "envoy", "Key prefix for archived Parquet files")
+	s3SitePtr     = flag.String("s3-site", "", "Site partition for archived Parquet files (default the Envoy host)")
+	s3EndpointPtr = flag.String("s3-endpoint", "", "S3 compatible endpoint URL, e.g. http://minio.local:9000 (default AWS)")
+	s3BufferPtr   = flag.String("s3-buffer", "envoy-s3-buffer.jsonl", "Local file readings are buffered in until they're archived")
+	s3FlushPtr    = flag.Duration("s3-flush", time.Hour, "How long to buffer readings before writing them to S3, 0 to write every run")
+)
+
+type s3Row struct {
+	Time        time.Time          `parquet:"time,timestamp(millisecond)" json:"time"`
+	Measurement string             `parquet:"measurement,dict" json:"measurement"`
+	Tags        map[string]string  `parquet:"tags" json:"tags"`
+	Fields      map[string]float64 `parquet:"fields" json:"fields"`
+}
+
+// writeS3Parquet adds points to the buffer, and archives it once it's due.
+// The buffer is rewritten rather than appended to, without the points it
+// already has, so that points retried after a failed upload aren't archived
+// twice, and partitions which were uploaded are left out of it.
+func writeS3Parquet(ctx context.Context, pts []*client.Point) error {
+	rows, err := readS3Buffer()
+	if err != nil {
+		return err
+	}
+	if rows, err = mergeS3Rows(rows, pts); err != nil {
+		return err
+	}
+	if err := writeS3Buffer(rows); err != nil {
+		return err
+	}
+	if len(rows) == 0 || time.Since(rows[0].Time) < *s3FlushPtr {
+		return nil
+	}
+
+	site := *s3SitePtr
+	if site == "" {
+		site = *envoyHostPtr
+	}
+	partitions := map[string][]s3Row{}
+	for _, row := range rows {
+		date := row.Time.Local().Format("2006-01-02")
+		partitions[date] = append(partitions[date], row)
+	}
+	return s3Conn.use(ctx, func(svc *s3.Client) error {
+		dates := sortedKeys(partitions)
+		for i, date := range dates {
+			if err := putS3Partition(ctx, svc, site, date, partitions[date]); err != nil {
+				left := []s3Row{}
+				for _, date := range dates[i:] {
+					left = append(left, partitions[date]...)
+				}
+				if bufErr := writeS3Buffer(left); bufErr != nil {
+					return bufErr
+				}
+				return err
+			}
+		}
+		return os.Remove(*s3BufferPtr)
+	})
+}
+
+// putS3Partition uploads a day's rows as a Parquet file
+func putS3Partition(ctx context.Context, svc *s3.Client, site, date string, partition []s3Row) error {
+	var buf bytes.Buffer
+	w := parquet.NewGenericWriter[s3Row](&buf, parquet.Compression(&parquet.Zstd))
+	if _, err := w.Write(partition); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	key := path.Join(*s3PrefixPtr, "site="+site, "date="+date,
+		fmt.Sprintf("%d-%d.parquet", partition[0].Time.Unix(), partition[len(partition)-1].Time.Unix()))
+	_, err := svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(*s3BucketPtr),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/vnd.apache.parquet"),
+	})
+	return err
+}
+
+// mergeS3Rows adds the points which aren't already buffered to rows, oldest
+// first
+func mergeS3Rows(rows []s3Row, pts []*client.Point) ([]s3Row, error) {
+	buffered := map[string]bool{}
+	for _, row := range rows {
+		buffered[s3RowKey(row)] = true
+	}
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		row := newS3Row(pt.Time(), pt.Name(), pt.Tags(), fields)
+		if key := s3RowKey(row); !buffered[key] {
+			buffered[key] = true
+			rows = append(rows, row)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Time.Before(rows[j].Time) })
+	return rows, nil
+}
+
+// s3RowKey identifies a row by its series and time
+func s3RowKey(row s3Row) string {
+	key := row.Measurement
+	for _, k := range sortedKeys(row.Tags) {
+		key += "," + k + "=" + row.Tags[k]
+	}
+	return key + " " + strconv.FormatInt(row.Time.UnixNano(), 10)
+}
+
+// newS3Row keeps the numeric fields of a point
+func newS3Row(t time.Time, measurement string, tags map[string]string, fields map[string]interface{}) s3Row {
+	values := map[string]float64{}
+	for k, v := range fields {
+		if value, ok := numericValue(v); ok {
+			values[k] = value
+		}
+	}
+	return s3Row{Time: t.UTC(), Measurement: measurement, Tags: tags, Fields: values}
+}
+
+// readS3Buffer reads the buffered rows, if there are any
+func readS3Buffer() ([]s3Row, error) {
+	f, err := os.Open(*s3BufferPtr)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rows := []s3Row{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line jsonLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, line.Time)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, newS3Row(t, line.Measurement, line.Tags, line.Fields))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// writeS3Buffer replaces the buffer with rows, as JSON lines
+func writeS3Buffer(rows []s3Row) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	tmp := *s3BufferPtr + ".tmp"
+	err := ioutil.WriteFile(tmp, buf.Bytes(), 0644)
+	if err == nil {
+		err = os.Rename(tmp, *s3BufferPtr)
+	}
+	return err
+}
+
+var s3Conn = newOutputConn(newS3Client, func(*s3.Client) {})
+
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if *s3EndpointPtr != "" {
+			o.BaseEndpoint = aws.String(*s3EndpointPtr)
+			o.UsePathStyle = true
+			if o.Region == "" {
+				o.Region = "us-east-1"
+			}
+		}
+	}), nil
+}
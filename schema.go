@@ -0,0 +1,218 @@
+// Effective schema
+
+// `influxEnvoyStats schema` prints every measurement, tag and field the current
+// configuration would write, with types and example values, to help design
+// Grafana queries and retention policies before any data is written.  The
+// examples are built with the same functions that build real points, and
+// named as they would be written, with -schema and any renames.
+
+package main
+
+import (
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"strings"
+	"time"
+)
+
+type schemaMeasurement struct {
+	when string
+	pts  []*client.Point
+}
+
+// Measurements written straight to InfluxDB, without renames
+var directMeasurements = []string{eventsMeasurement, dailyCurveMeasurement, batteryDailyMeasurement}
+
+func printSchema() error {
+	now := time.Now()
+	percentFull := 67.0
+	// built keeps the first error building an example point
+	var buildErr error
+	built := func(pt *client.Point, err error) *client.Point {
+		if buildErr == nil {
+			buildErr = err
+		}
+		return pt
+	}
+	// example builds the point for an example reading
+	example := func(reading Eim) *client.Point {
+		fields := readingFields(reading)
+		fields["wh_lifetime"] = reading.WhLifetime
+		if reading.MeasurementType == "net-consumption" {
+			fields["grid_import_watts"], fields["grid_export_watts"] = 0.0, 2722.482
+			fields["grid_import_wh_today"], fields["grid_export_wh_today"] = 1840.2, 9766.4
+			if importTariff != nil {
+				fields["grid_import_cost"], fields["grid_import_cost_today"] = 0.0, 0.552
+			}
+			if exportTariff != nil {
+				fields["grid_export_credit"], fields["grid_export_credit_today"] = 0.0227, 0.488
+			}
+		}
+		if reading.MeasurementType == "production" && (carbonSchedule != nil || *carbonAPIPtr != "") {
+			fields["grid_carbon_intensity"], fields["co2_avoided_g"], fields["co2_avoided_g_today"] = 412.0, 20.44, 5486.9
+		}
+		return built(readingPoint(reading.MeasurementType, fields, now))
+	}
+	measurements := []schemaMeasurement{
+		{"every run", []*client.Point{
+			example(Eim{MeasurementType: "production", WNow: 2977.73, WhToday: 13318.305, WhLastSevenDays: 98211.6, WhLifetime: 12405326.1,
+				RmsVoltage: 239.777, RmsCurrent: 12.491, PwrFactor: 1.0, ReactPwr: 339.018, ApprntPwr: 2993.495,
+				VahToday: 14691.091, VahLifetime: 5315246.091, VarhLeadToday: 0.009, VarhLagToday: 3257.976, VarhLeadLifetime: 0.009, VarhLagLifetime: 1630768.976}),
+			example(Eim{MeasurementType: "total-consumption", WNow: 255.247, WhToday: 3573.926, WhLastSevenDays: 25931.2, WhLifetime: 8479568.7,
+				RmsVoltage: 239.902, RmsCurrent: 0.873, PwrFactor: 1.0, ReactPwr: -952.491, ApprntPwr: 209.319,
+				VahToday: 14972.984, VahLifetime: 8409172.984, VarhLeadToday: 6269.177, VarhLagToday: 3258.995, VarhLeadLifetime: 2939845.177, VarhLagLifetime: 1633886.995}),
+			example(Eim{MeasurementType: "net-consumption", WNow: -2722.482, WhToday: 0.0, WhLastSevenDays: 2114.5, WhLifetime: 3925757.449,
+				RmsVoltage: 240.027, RmsCurrent: 11.618, PwrFactor: -0.98, ReactPwr: -613.473, ApprntPwr: 2787.592,
+				VahLifetime: 8409172.984, VarhLeadLifetime: 2939845.168, VarhLagLifetime: 3118.019}),
+			built(standbyPoint(Eim{ReadingTime: now.Unix(), WNow: -3.5})),
+		}},
+		{"with batteries", []*client.Point{
+			built(storagePoint(Storage{Type: "acb", ReadingTime: now.Unix(), WNow: -1200, WhNow: 6700, PercentFull: &percentFull})),
+		}},
+	}
+	resetFields := map[string]interface{}{"watts": 2977.73, "wh_today": 13318.305, "wh_lifetime": 1520.4, "counter_reset": true}
+	if *monotonicCountersPtr {
+		resetFields["wh_lifetime_adjusted"] = 12406846.5
+	}
+	measurements = append(measurements, schemaMeasurement{"on a lifetime counter reset", []*client.Point{
+		built(readingPoint("production", resetFields, now)),
+	}})
+	if collecting("phases") {
+		production := Eim{MeasurementType: "production", ReadingTime: now.Unix()}
+		measurements = append(measurements, schemaMeasurement{"with phases collected", []*client.Point{
+			built(phasePoint(production, 0, Eim{WNow: 1490.2, WhToday: 6659.1, WhLastSevenDays: 49105.8, WhLifetime: 2184119.6,
+				RmsVoltage: 119.8, RmsCurrent: 12.5, PwrFactor: 0.99, ReactPwr: 170.1, ApprntPwr: 1497.4})),
+			built(phasePoint(production, 1, Eim{WNow: 1487.5, WhToday: 6659.2, WhLastSevenDays: 49105.8, WhLifetime: 2184119.7,
+				RmsVoltage: 120.1, RmsCurrent: 12.4, PwrFactor: 0.99, ReactPwr: 168.9, ApprntPwr: 1494.6})),
+		}})
+	}
+	if collecting("inverters") {
+		measurements = append(measurements, schemaMeasurement{"with inverters collected", []*client.Point{
+			built(inverterPoint(inverterReading{SerialNumber: "121812345678", LastReportDate: now.Unix(), LastReportWatts: 243, MaxReportWatts: 290})),
+		}})
+	}
+	if collecting("meters") {
+		measurements = append(measurements, schemaMeasurement{"with meters collected", []*client.Point{
+			built(meterPoint("production", meterReading{Eid: 704643328, Timestamp: now.Unix(), ActEnergyDlvd: 3925757.4, ActivePower: 2977.7,
+				ApparentPower: 2990.1, ReactivePower: 272.4, PwrFactor: 0.99, Voltage: 240.0, Current: 12.4, Freq: 60.01})),
+		}})
+	}
+	if collecting("inventory") {
+		measurements = append(measurements, schemaMeasurement{"with inventory collected", []*client.Point{
+			built(devicePoint("pcu", inventoryDevice{SerialNum: "121935144671", LastRptDate: fmt.Sprint(now.Unix()), DeviceStatus: []string{"envoy.global.ok"},
+				Producing: true, Communicating: true, Operating: true})),
+		}})
+	}
+	if collecting("ensemble") {
+		measurements = append(measurements, schemaMeasurement{"with ensemble collected", []*client.Point{
+			built(enchargePoint(enchargeDevice{SerialNum: "122000000001", LastRptDate: now.Unix(), PercentFull: 87, Temperature: 29, MaxCellTemp: 31,
+				EnchargeCapacity: 3360, Communicating: true, Operating: true})),
+		}})
+	}
+	if collecting("events") {
+		measurements = append(measurements, schemaMeasurement{"with events collected", []*client.Point{
+			built(eventPoint(busEvent{Type: "envoy", Title: "Microinverter failed to report", Text: "121935144671 (PCU)", Time: now})),
+		}})
+	}
+	if *anomalyWindowPtr > 0 {
+		measurements = append(measurements, schemaMeasurement{"on consumption anomalies", []*client.Point{
+			built(eventPoint(busEvent{Type: "anomaly", Title: "Consumption base load jump", Text: "Consumption has stayed above 900 W for 2h0m0s, usual base load is 250 W", Time: now})),
+		}})
+	}
+	curvePt, _, err := dailyCurvePoint(now, []uint16{0, 0, 120, 850, 2300, 850, 120, 0}, 2300, 4240)
+	measurements = append(measurements, schemaMeasurement{"with -daily-curve", []*client.Point{built(curvePt, err)}})
+	batteryPt, _, err := batterySummaryPoint(now, 2100, 1900, 6800, 6150, 10080, 412.3)
+	measurements = append(measurements, schemaMeasurement{"with -battery-summary", []*client.Point{built(batteryPt, err)}})
+	if buildErr != nil {
+		return fmt.Errorf("building the example points: %w", buildErr)
+	}
+
+	for _, m := range measurements {
+		pts, err := applyTimestamps(m.pts, now)
+		for _, step := range []func([]*client.Point) ([]*client.Point, error){selectFields, roundFields, tagVersion} {
+			if err != nil {
+				break
+			}
+			pts, err = step(pts)
+		}
+		direct := contains(directMeasurements, m.pts[0].Name())
+		if err == nil && *schemaPtr == 2 && !direct {
+			// With an example serial number, rather than asking the Envoy
+			pts, err = v2Points(pts, schemaSite(), "122012345678")
+		}
+		if err == nil && !direct {
+			pts, err = renamePoints(pts)
+		}
+		if err != nil {
+			return fmt.Errorf("preparing the example points: %w", err)
+		}
+		// With -type-measurements, readings can be written to several
+		names := []string{}
+		byName := map[string][]*client.Point{}
+		for _, pt := range pts {
+			if _, ok := byName[pt.Name()]; !ok {
+				names = append(names, pt.Name())
+			}
+			byName[pt.Name()] = append(byName[pt.Name()], pt)
+		}
+		for _, name := range names {
+			if err := printSchemaMeasurement(name, m.when, byName[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printSchemaMeasurement prints the tags and fields of a measurement's points
+func printSchemaMeasurement(name, when string, pts []*client.Point) error {
+	fmt.Printf("%s (%s)\n", name, when)
+	tagValues := map[string][]string{}
+	fieldExamples := map[string]interface{}{}
+	for _, pt := range pts {
+		for k, v := range pt.Tags() {
+			if !contains(tagValues[k], v) {
+				tagValues[k] = append(tagValues[k], v)
+			}
+		}
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		for k, v := range fields {
+			if _, ok := fieldExamples[k]; !ok {
+				fieldExamples[k] = v
+			}
+		}
+	}
+
+	for _, k := range sortedKeys(tagValues) {
+		fmt.Printf("  tag   %-27s %s\n", k, strings.Join(tagValues[k], ", "))
+	}
+	for _, k := range sortedKeys(fieldExamples) {
+		fmt.Printf("  field %-27s %-8s e.g. %s\n", k, influxType(fieldExamples[k]), schemaExample(fieldExamples[k]))
+	}
+	return nil
+}
+
+func influxType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "float"
+	case int64:
+		return "integer"
+	case bool:
+		return "boolean"
+	}
+	return "string"
+}
+
+func schemaExample(v interface{}) string {
+	if s, ok := v.(string); ok {
+		if len(s) > 40 {
+			s = s[:37] + "..."
+		}
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,223 @@
+// Schema version 2
+
+// The measurements, tags and fields first written grew one at a time, so are
+// named inconsistently and don't say which site or Envoy they came from.
+// -schema 2 writes readings with a consistent schema instead:
+//
+//	meters     type, phase (total, l1, l2, l3), site, envoy_serial
+//	           power_w, energy_today_wh, energy_last_seven_days_wh,
+//	           energy_lifetime_wh, energy_lifetime_adjusted_wh, counter_reset,
+//	           grid_import_power_w, grid_export_power_w,
+//	           grid_import_energy_today_wh, grid_export_energy_today_wh,
+//	           grid_import_cost, grid_export_credit, grid_import_cost_today,
+//	           grid_export_credit_today, co2_avoided_g, co2_avoided_today_g,
+//	           grid_carbon_intensity_g_per_kwh,
+//	           voltage_v, current_a, power_factor, reactive_power_var,
+//	           apparent_power_va, apparent_energy_today_vah,
+//	           apparent_energy_lifetime_vah, reactive_energy_lead_today_varh,
+//	           reactive_energy_lag_today_varh,
+//	           reactive_energy_lead_lifetime_varh,
+//	           reactive_energy_lag_lifetime_varh
+//	batteries  site, envoy_serial
+//	           power_w, energy_wh, charge_percent
+//	inverters  serial, site, envoy_serial
+//	           power_w, max_power_w
+//
+// with units in the field names and every phase collected.  Events and daily
+// curves are as before.  Points are collected in schema 1 and converted as
+// they're written, so -bounds, -exclude-fields and the like use schema 1's
+// names, and Domoticz, openHAB and PVOutput are given schema 1.  The migrate
+// command (see migrate.go) copies what was written with schema 1.
+
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"log"
+	"sync"
+)
+
+var (
+	schemaPtr = flag.Int("schema", 1, "Version of the measurements, tags and fields to write, 1 or 2 (see README)")
+	sitePtr   = flag.String("site", "", "With -schema 2, the site tag, by default the Envoy's address")
+)
+
+const latestSchema = 2
+
+// Schema 2's names for schema 1's measurements, but for storage readings
+var v2Measurements = map[string]string{
+	"readings":           "meters",
+	phasesMeasurement:    "meters",
+	invertersMeasurement: "inverters",
+}
+
+// Schema 2's names for schema 1's fields
+var v2FieldNames = map[string]string{
+	"watts":                 "power_w",
+	"max_watts":             "max_power_w",
+	"wh_today":              "energy_today_wh",
+	"wh_last_seven_days":    "energy_last_seven_days_wh",
+	"wh_lifetime":           "energy_lifetime_wh",
+	"wh_lifetime_adjusted":  "energy_lifetime_adjusted_wh",
+	"wh_now":                "energy_wh",
+	"percent_full":          "charge_percent",
+	"grid_import_watts":     "grid_import_power_w",
+	"grid_export_watts":     "grid_export_power_w",
+	"grid_import_wh_today":  "grid_import_energy_today_wh",
+	"grid_export_wh_today":  "grid_export_energy_today_wh",
+	"co2_avoided_g_today":   "co2_avoided_today_g",
+	"grid_carbon_intensity": "grid_carbon_intensity_g_per_kwh",
+	"volts":                 "voltage_v",
+	"amps":                  "current_a",
+	"vars":                  "reactive_power_var",
+	"va":                    "apparent_power_va",
+	"vah_today":             "apparent_energy_today_vah",
+	"vah_lifetime":          "apparent_energy_lifetime_vah",
+	"varh_lead_today":       "reactive_energy_lead_today_varh",
+	"varh_lag_today":        "reactive_energy_lag_today_varh",
+	"varh_lead_lifetime":    "reactive_energy_lead_lifetime_varh",
+	"varh_lag_lifetime":     "reactive_energy_lag_lifetime_varh",
+}
+
+func setupSchema() error {
+	switch *schemaPtr {
+	case 1:
+	case 2:
+		given := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			given[f.Name] = true
+		})
+		if !given["collect"] {
+			if err := deriveFlag("collect", *collectPtr+",phases", "-schema 2"); err != nil {
+				return err
+			}
+		}
+	default:
+		return configError("unknown -schema %d, use 1 or 2", *schemaPtr)
+	}
+	return nil
+}
+
+// schemaPoints converts points to the schema written, as -schema says
+func schemaPoints(ctx context.Context, pts []*client.Point) ([]*client.Point, error) {
+	if *schemaPtr != 2 {
+		return pts, nil
+	}
+	return v2Points(pts, schemaSite(), envoySerialNumber(ctx))
+}
+
+// schemaSite is the site tag's value
+func schemaSite() string {
+	if *sitePtr != "" {
+		return *sitePtr
+	}
+	return *envoyHostPtr
+}
+
+// v2Points converts schema 1 points to schema 2, from site and envoySerial
+func v2Points(pts []*client.Point, site, envoySerial string) ([]*client.Point, error) {
+	converted := make([]*client.Point, 0, len(pts))
+	for _, pt := range pts {
+		name := pt.Name()
+		if name == *measurementNamePtr {
+			name = "readings"
+		}
+		v2Name, ok := v2Measurements[name]
+		if !ok {
+			converted = append(converted, pt)
+			continue
+		}
+
+		tags := pt.Tags()
+		tags["site"] = site
+		if envoySerial != "" {
+			tags["envoy_serial"] = envoySerial
+		}
+		if name == "readings" {
+			if tags["type"] == "storage" {
+				v2Name = "batteries"
+				delete(tags, "type")
+			} else {
+				tags["phase"] = "total"
+			}
+		}
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		v2Fields := make(map[string]interface{}, len(fields))
+		for k, v := range fields {
+			if v2, ok := v2FieldNames[k]; ok {
+				k = v2
+			}
+			v2Fields[k] = v
+		}
+		pt, err = newPoint(v2Name, tags, v2Fields, pt.Time())
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, pt)
+	}
+	return converted, nil
+}
+
+// The Envoy's serial number, once read
+var (
+	envoySerial   string
+	envoySerialMu sync.Mutex
+)
+
+// envoySerialNumber reads the Envoy's serial number from its /info.xml,
+// keeping it once read, or is empty if it can't be read
+func envoySerialNumber(ctx context.Context) string {
+	envoySerialMu.Lock()
+	defer envoySerialMu.Unlock()
+	if envoySerial != "" {
+		return envoySerial
+	}
+	info, err := readEnvoyInfo(ctx)
+	if err == nil {
+		envoySerial = info.Serial
+	} else {
+		log.Printf(journalPriority(4, "Writing without the envoy_serial tag, as the Envoy's serial number couldn't be read: %v"), err)
+	}
+	return envoySerial
+}
+
+// readEnvoyInfo reads the Envoy's /info.xml through -envoy-host
+func readEnvoyInfo(ctx context.Context) (envoyInfo, error) {
+	var info envoyInfo
+	resp, err := getEnvoy(ctx, "/info.xml")
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if err := xml.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return info, fmt.Errorf("decoding /info.xml: %w", err)
+	}
+	return info, nil
+}
+
+// readingsMeasurement is the measurement readings are written to, as -schema
+// says
+func readingsMeasurement() string {
+	if *schemaPtr == 2 {
+		return "meters"
+	}
+	return *measurementNamePtr
+}
+
+// powerSeries gives the measurement, field and condition to query a type of
+// reading's power with, as written with -schema and any renames
+func powerSeries(measurementType string) (string, string, string) {
+	if *schemaPtr == 2 {
+		names := currentRenames().series(readingsMeasurement(), map[string]string{"type": measurementType, "phase": "total"})
+		return names.measurement, names.field("power_w"), names.where()
+	}
+	names := currentRenames().series(readingsMeasurement(), map[string]string{"type": measurementType})
+	return names.measurement, names.field("watts"), names.where()
+}
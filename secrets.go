@@ -0,0 +1,97 @@
+// Secrets from the environment
+
+// Secrets not given as flags are taken from environment variables, so they are
+// not visible in ps: the prefixed variable for the setting (see environment.go),
+// or failing that the secret's original variable below.  For Docker/Kubernetes
+// secrets, NAME_FILE gives the path of a file holding the secret instead, e.g.
+// DB_PW_FILE=/run/secrets/db_pw
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Original environment variables for each secret flag
+var secretEnvVars = map[string]string{
+	"amqp-url":               "AMQP_URL",
+	"api-keys":               "API_KEYS",
+	"carbon-key":             "CARBON_API_KEY",
+	"clickhouse-pw":          "CLICKHOUSE_PASSWORD",
+	"dbp":                    "DB_PW",
+	"domoticz-pw":            "DOMOTICZ_PASSWORD",
+	"emoncms-key":            "EMONCMS_KEY",
+	"envoy-pw":               "ENVOY_PASSWORD",
+	"envoy-token":            "ENVOY_TOKEN",
+	"influx-token":           "INFLUX_TOKEN",
+	"kafka-pw":               "KAFKA_PASSWORD",
+	"mqtt-pw":                "MQTT_PASSWORD",
+	"openhab-token":          "OPENHAB_TOKEN",
+	"pg-url":                 "PG_URL",
+	"pvoutput-key":           "PVOUTPUT_KEY",
+	"questdb-key":            "QUESTDB_KEY",
+	"redis-url":              "REDIS_URL",
+	"secondary-dbp":          "SECONDARY_DB_PW",
+	"secondary-influx-token": "SECONDARY_INFLUX_TOKEN",
+	"webhook-secret":         "WEBHOOK_SECRET",
+}
+
+func secretsFromEnv() error {
+	set := explicitFlags()
+	for name, envVar := range secretEnvVars {
+		if set[name] {
+			continue
+		}
+		secret, from, ok, err := settingFromEnv(name)
+		if err == nil && !ok {
+			from = envVar
+			if _, isFile := os.LookupEnv(envVar + "_FILE"); isFile {
+				from += "_FILE"
+			}
+			secret, ok, err = secretFromEnv(envVar)
+		}
+		if err != nil {
+			return configError("%s: %v", from, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := flag.Set(name, secret); err != nil {
+			return configError("%s: %v", from, err)
+		}
+		envFlags[name] = from
+	}
+	return nil
+}
+
+var commandLineFlags map[string]bool
+
+// explicitFlags returns the names of flags given on the command line, which
+// take precedence over secrets from elsewhere.  They're noted on the first call,
+// before any secrets are set, so that secrets can be read again on reload.
+func explicitFlags() map[string]bool {
+	if commandLineFlags == nil {
+		commandLineFlags = map[string]bool{}
+		flag.Visit(func(f *flag.Flag) {
+			commandLineFlags[f.Name] = true
+		})
+	}
+	return commandLineFlags
+}
+
+// secretFromEnv reads a secret from the file named by envVar_FILE, or failing
+// that from envVar itself
+func secretFromEnv(envVar string) (string, bool, error) {
+	if path, ok := os.LookupEnv(envVar + "_FILE"); ok {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", false, err
+		}
+		return strings.TrimRight(string(data), "\r\n"), true, nil
+	}
+	value, ok := os.LookupEnv(envVar)
+	return value, ok, nil
+}
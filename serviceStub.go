@@ -0,0 +1,19 @@
+// Windows service stubs
+
+// Outside Windows, a daemon runs under systemd or Docker instead.
+
+//go:build !windows
+
+package main
+
+func isWindowsService() (bool, error) {
+	return false, nil
+}
+
+func runWindowsService() error {
+	return nil
+}
+
+func serviceCommand(command string) error {
+	return configError("the service command is only for Windows, see the README for running under systemd")
+}
@@ -0,0 +1,130 @@
+// Simulated readings, for building dashboards before a real system is available
+
+// Generates back-dated production and consumption readings with the same schema
+// as real Envoy readings.  Each day gets a weather pattern (clear, partly cloudy
+// or overcast) which scales the production curve, and consumption is a base load
+// with a fridge cycle, morning/evening peaks and the odd kettle, by local time.
+// They're written to each -output, as collected readings are.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	simulatePeakWatts    = 4000.0
+	simulateStandbyWatts = 4.0
+	simulateStep         = time.Minute
+)
+
+type weather struct {
+	name     string
+	minScale float64
+	maxScale float64
+	cloudy   float64 // Chance per step of a passing cloud
+}
+
+var weathers = []weather{
+	{"clear", 0.9, 1.0, 0.002},
+	{"partly cloudy", 0.6, 0.9, 0.05},
+	{"overcast", 0.15, 0.35, 0.01},
+}
+
+func simulate(days int) error {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	w := weathers[0]
+
+	for d := days; d > 0; d-- {
+		day := today.AddDate(0, 0, -d)
+		// Weather tends to persist from one day to the next
+		if rnd.Float64() < 0.4 {
+			w = weathers[rnd.Intn(len(weathers))]
+		}
+		pts, whProduced, err := simulateDay(rnd, day, w)
+		if err != nil {
+			return err
+		}
+		if err := writeOutputs(context.Background(), pts); err != nil {
+			return err
+		}
+		fmt.Fprintf(console, "%s %s: %.0f Wh produced\n", day.Format("2006-01-02"), w.name, whProduced)
+	}
+	return nil
+}
+
+func simulateDay(rnd *rand.Rand, day time.Time, w weather) ([]*client.Point, float64, error) {
+	// Day length varies through the year by +/- 2 hours around 12
+	seasonal := math.Cos(2 * math.Pi * float64(day.YearDay()-172) / 365)
+	dayLength := (12 + 2*seasonal) * float64(time.Hour)
+	noon := time.Date(day.Year(), day.Month(), day.Day(), 12, 0, 0, 0, time.Local)
+	sunrise := noon.Add(-time.Duration(dayLength / 2))
+
+	scale := w.minScale + rnd.Float64()*(w.maxScale-w.minScale)
+	cloud := 0.0
+	kettle := 0
+	whProduced, whConsumed := 0.0, 0.0
+
+	pts := []*client.Point{}
+	for t := day; t.Before(day.AddDate(0, 0, 1)); t = t.Add(simulateStep) {
+		// Production follows the sun, scaled by the day's weather and passing clouds.
+		// At night the PV system draws a little standby power instead.
+		production := -simulateStandbyWatts
+		sinceSunrise := float64(t.Sub(sunrise))
+		if sinceSunrise > 0 && sinceSunrise < dayLength {
+			if rnd.Float64() < w.cloudy {
+				cloud = 0.3 + rnd.Float64()*0.5
+			}
+			cloud *= 0.9
+			production = simulatePeakWatts * scale * (1 - cloud) * math.Sin(math.Pi*sinceSunrise/dayLength)
+		}
+
+		// Consumption is a base load, the fridge cycling, and people being home
+		hour := float64(t.Hour()) + float64(t.Minute())/60
+		consumption := 180 + rnd.Float64()*20
+		if t.Minute()%45 < 15 {
+			consumption += 90
+		}
+		if hour >= 6.5 && hour < 8 {
+			consumption += 400
+		}
+		if hour >= 17 && hour < 21 {
+			consumption += 600
+		}
+		if hour >= 6.5 && hour < 22 && rnd.Float64() < 0.01 {
+			kettle = 3
+		}
+		if kettle > 0 {
+			consumption += 2000
+			kettle--
+		}
+
+		whProduced += math.Max(production, 0) * simulateStep.Hours()
+		whConsumed += consumption * simulateStep.Hours()
+		readings := map[string]map[string]interface{}{
+			"production":        {"watts": production, "wh_today": whProduced},
+			"total-consumption": {"watts": consumption, "wh_today": whConsumed},
+			"net-consumption":   {"watts": consumption - production, "wh_today": 0.0},
+		}
+		for _, measurementType := range []string{"production", "total-consumption", "net-consumption"} {
+			pt, err := readingPoint(measurementType, readings[measurementType], t)
+			if err != nil {
+				return nil, 0, err
+			}
+			pts = append(pts, pt)
+		}
+		pt, err := standbyPoint(Eim{ReadingTime: t.Unix(), WNow: production})
+		if err != nil {
+			return nil, 0, err
+		}
+		pts = append(pts, pt)
+	}
+	return pts, whProduced, nil
+}
@@ -0,0 +1,260 @@
+// MQTT Sparkplug B output
+
+// Publishes readings as a Sparkplug B edge node, for SCADA hosts such as
+// Ignition.  The node keeps one session on the -mqtt-broker, as the
+// -mqtt-client-id with -sparkplug added so as not to displace the MQTT output.
+// On connecting, an NBIRTH declares every metric with its name, alias and data
+// type along with its first values, and further values follow as NDATA using
+// aliases only.  A metric not seen before means another NBIRTH declaring it.
+// The session ends with an NDEATH when the connection is closed, on stopping
+// or reloading, which is also the connection's will should the node vanish.
+// Metrics are named after the point's tag values and field, e.g.
+// production/watts, with the measurement first if it isn't -m.
+// The payloads are encoded directly from the Sparkplug B protobuf definition.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/influxdata/influxdb/client/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	sparkplugGroupPtr = flag.String("sparkplug-group", "Solar", "Sparkplug B group ID")
+	sparkplugNodePtr  = flag.String("sparkplug-node", "envoy", "Sparkplug B edge node ID")
+)
+
+// Sparkplug B data types
+const (
+	sparkplugInt64   = 4
+	sparkplugUInt64  = 8
+	sparkplugDouble  = 10
+	sparkplugBoolean = 11
+	sparkplugString  = 12
+)
+
+type sparkplugMetric struct {
+	name     string
+	alias    uint64
+	time     time.Time
+	dataType uint64
+	value    interface{}
+}
+
+// sparkplugSession is the edge node's session on the broker
+type sparkplugSession struct {
+	client  mqtt.Client
+	bdSeq   sparkplugMetric
+	seq     uint64
+	born    bool
+	aliases map[string]uint64          // Of the metrics declared in the NBIRTH
+	last    map[string]sparkplugMetric // Each metric's latest value
+}
+
+// Each connection's birth/death sequence number
+var sparkplugBdSeq uint64
+
+var sparkplugConn = newOutputConn(connectSparkplug, func(s *sparkplugSession) {
+	mqttWait(context.Background(), s.client.Publish(sparkplugTopic("NDEATH"), 1, false, sparkplugPayload(time.Now(), nil, s.bdSeq)))
+	s.client.Disconnect(250)
+})
+
+func connectSparkplug(ctx context.Context) (*sparkplugSession, error) {
+	now := time.Now()
+	bdSeq := sparkplugMetric{"bdSeq", 0, now, sparkplugUInt64, sparkplugBdSeq}
+	sparkplugBdSeq = (sparkplugBdSeq + 1) % 256
+	opts, err := mqttOptions()
+	if err != nil {
+		return nil, err
+	}
+	// A lost connection fails the next write, so that the session is begun
+	// again with an NBIRTH
+	opts.SetClientID(*mqttClientIdPtr+"-sparkplug").
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetBinaryWill(sparkplugTopic("NDEATH"), sparkplugPayload(now, nil, bdSeq), 1, false)
+	c := mqtt.NewClient(opts)
+	if err := mqttWait(ctx, c.Connect()); err != nil {
+		return nil, err
+	}
+	return &sparkplugSession{
+		client:  c,
+		bdSeq:   bdSeq,
+		aliases: map[string]uint64{},
+		last:    map[string]sparkplugMetric{},
+	}, nil
+}
+
+func publishSparkplug(ctx context.Context, pts []*client.Point) error {
+	// Metrics in time order
+	metrics := []sparkplugMetric{}
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		for _, k := range sortedKeys(fields) {
+			metrics = append(metrics, sparkplugMetric{sparkplugMetricName(pt, k), 0, pt.Time(), sparkplugDataType(fields[k]), fields[k]})
+		}
+	}
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].time.Before(metrics[j].time) })
+	return sparkplugConn.use(ctx, func(s *sparkplugSession) error {
+		return s.publish(ctx, metrics)
+	})
+}
+
+// publish sends metrics as NDATA, after an NBIRTH if the session hasn't had
+// one or any of them are new
+func (s *sparkplugSession) publish(ctx context.Context, metrics []sparkplugMetric) error {
+	if !s.client.IsConnectionOpen() {
+		return fmt.Errorf("sparkplug %s: connection lost", *mqttBrokerPtr)
+	}
+	rebirth := !s.born
+	for i, m := range metrics {
+		if _, ok := s.aliases[m.name]; !ok {
+			s.aliases[m.name] = uint64(len(s.aliases) + 1)
+			rebirth = true
+		}
+		metrics[i].alias = s.aliases[m.name]
+	}
+
+	data := metrics
+	if rebirth {
+		// The NBIRTH has each metric's first value here, or else its latest,
+		// and the node controls
+		now := time.Now()
+		first := map[string]sparkplugMetric{}
+		data = []sparkplugMetric{}
+		for _, m := range metrics {
+			if _, ok := first[m.name]; ok {
+				data = append(data, m)
+			} else {
+				first[m.name] = m
+			}
+		}
+		birth := []sparkplugMetric{s.bdSeq, {"Node Control/Rebirth", 0, now, sparkplugBoolean, false}}
+		for _, name := range sortedKeys(s.aliases) {
+			if m, ok := first[name]; ok {
+				birth = append(birth, m)
+			} else if m, ok := s.last[name]; ok {
+				m.name = name
+				birth = append(birth, m)
+			}
+		}
+		s.seq = 0
+		if err := mqttWait(ctx, s.client.Publish(sparkplugTopic("NBIRTH"), 0, false, sparkplugPayload(now, &s.seq, birth...))); err != nil {
+			return err
+		}
+		s.born = true
+		for _, m := range first {
+			s.last[m.name] = m
+		}
+	}
+
+	for len(data) > 0 {
+		// One NDATA per timestamp, by alias
+		n := 1
+		for n < len(data) && data[n].time.Equal(data[0].time) {
+			n++
+		}
+		batch := make([]sparkplugMetric, n)
+		for i, m := range data[:n] {
+			s.last[m.name] = m
+			m.name = ""
+			batch[i] = m
+		}
+		s.seq = (s.seq + 1) % 256
+		if err := mqttWait(ctx, s.client.Publish(sparkplugTopic("NDATA"), 0, false, sparkplugPayload(data[0].time, &s.seq, batch...))); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func sparkplugTopic(messageType string) string {
+	return "spBv1.0/" + *sparkplugGroupPtr + "/" + messageType + "/" + *sparkplugNodePtr
+}
+
+func sparkplugMetricName(pt *client.Point, field string) string {
+	parts := []string{}
+	if pt.Name() != *measurementNamePtr {
+		parts = append(parts, pt.Name())
+	}
+	tags := pt.Tags()
+	for _, k := range sortedKeys(tags) {
+		parts = append(parts, tags[k])
+	}
+	return strings.Join(append(parts, field), "/")
+}
+
+func sparkplugDataType(v interface{}) uint64 {
+	switch v.(type) {
+	case float64:
+		return sparkplugDouble
+	case int64:
+		return sparkplugInt64
+	case bool:
+		return sparkplugBoolean
+	}
+	return sparkplugString
+}
+
+// sparkplugPayload encodes a Sparkplug B Payload message, with no seq for NDEATH
+func sparkplugPayload(t time.Time, seq *uint64, metrics ...sparkplugMetric) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(t.UnixNano()/1e6))
+	for _, m := range metrics {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sparkplugMetricBytes(m))
+	}
+	if seq != nil {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, *seq)
+	}
+	return b
+}
+
+// sparkplugMetricBytes encodes a Payload.Metric message, by alias alone if it
+// has no name
+func sparkplugMetricBytes(m sparkplugMetric) []byte {
+	var b []byte
+	if m.name != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.name)
+	}
+	if m.alias != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, m.alias)
+	}
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.time.UnixNano()/1e6))
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, m.dataType)
+	switch v := m.value.(type) {
+	case uint64:
+		b = protowire.AppendTag(b, 11, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	case int64:
+		b = protowire.AppendTag(b, 11, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	case float64:
+		b = protowire.AppendTag(b, 13, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case bool:
+		b = protowire.AppendTag(b, 14, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	default:
+		b = protowire.AppendTag(b, 15, protowire.BytesType)
+		b = protowire.AppendString(b, fmt.Sprint(v))
+	}
+	return b
+}
@@ -0,0 +1,153 @@
+// Disk spool
+
+// With -spool-dir, points an output couldn't take are appended to a line
+// protocol file for it in that directory, rather than kept in memory, so they
+// survive long outages and restarts.  Once the output takes points again, the
+// spool is replayed in order, -output-buffer points at a time, before anything
+// newer is written.  Each spool is limited to -spool-max bytes, beyond which
+// the oldest points are dropped.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/influxdata/influxdb/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	spoolDirPtr = flag.String("spool-dir", "", "Directory to spool points outputs couldn't take to, for replaying once they're back (default keep -output-buffer points in memory)")
+	spoolMaxPtr = flag.Int64("spool-max", 100<<20, "Most bytes to spool per output, beyond which the oldest points are dropped")
+)
+
+// spool is an output's file of points waiting to be written
+type spool struct {
+	path  string
+	mu    sync.Mutex // Guards the counts, for status while writing
+	lines int
+	bytes int64
+}
+
+// openSpool opens an output's spool, counting any points left in it from
+// before
+func openSpool(output string) (*spool, error) {
+	if err := os.MkdirAll(*spoolDirPtr, 0755); err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	s := &spool{path: filepath.Join(*spoolDirPtr, output+".lp")}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	s.lines = bytes.Count(data, []byte("\n"))
+	s.bytes = int64(len(data))
+	return s, nil
+}
+
+func (s *spool) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lines
+}
+
+// append adds points to the end of the spool, returning how many of the
+// oldest were dropped to keep within -spool-max
+func (s *spool) append(pts []*client.Point) (int, error) {
+	var buf bytes.Buffer
+	if err := writeLines(&buf, pts); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("spool: %w", err)
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("spool: %w", err)
+	}
+
+	s.mu.Lock()
+	s.lines += len(pts)
+	s.bytes += int64(buf.Len())
+	excess := s.bytes - *spoolMaxPtr
+	s.mu.Unlock()
+	if excess <= 0 {
+		return 0, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return 0, fmt.Errorf("spool: %w", err)
+	}
+	dropped := 0
+	for int64(len(data)) > *spoolMaxPtr {
+		i := bytes.IndexByte(data, '\n')
+		data = data[i+1:]
+		dropped++
+	}
+	return dropped, s.rewrite(data, dropped)
+}
+
+// peek reads the oldest n points
+func (s *spool) peek(n int) ([]*client.Point, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	defer f.Close()
+	pts := []*client.Point{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for len(pts) < n && scanner.Scan() {
+		parsed, err := models.ParsePointsWithPrecision(scanner.Bytes(), time.Now().UTC(), "n")
+		if err != nil {
+			return nil, fmt.Errorf("spool %s: %w", s.path, err)
+		}
+		for _, pt := range parsed {
+			pts = append(pts, client.NewPointFrom(pt))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("spool %s: %w", s.path, err)
+	}
+	return pts, nil
+}
+
+// remove drops the oldest n points, once they've been written
+func (s *spool) remove(n int) error {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	for i := 0; i < n && len(data) > 0; i++ {
+		data = data[bytes.IndexByte(data, '\n')+1:]
+	}
+	return s.rewrite(data, n)
+}
+
+// rewrite replaces the spool with data, which has removed points fewer
+func (s *spool) rewrite(data []byte, removed int) error {
+	tmp := s.path + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+	s.mu.Lock()
+	s.lines -= removed
+	s.bytes = int64(len(data))
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,199 @@
+// SQLite output
+
+// For tiny installs with no database server.  Each measurement is written to a
+// table of the same name in the -sqlite-path file, with time as unix seconds, a
+// text column per tag and a column per field, created as needed.  Rows older
+// than -sqlite-retention are pruned on every write.
+// > influxEnvoyStats sqlite-latest
+// reads back the latest row of each table.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	_ "modernc.org/sqlite"
+	"strings"
+	"time"
+)
+
+var (
+	sqlitePathPtr      = flag.String("sqlite-path", "envoy.db", "SQLite database file")
+	sqliteRetentionPtr = flag.Duration("sqlite-retention", 0, "Delete SQLite rows older than this, e.g. 8760h (0 keeps everything)")
+)
+
+func openSQLite() (*sql.DB, error) {
+	db, err := sql.Open("sqlite", *sqlitePathPtr)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+var sqliteConn = newOutputConn(func(context.Context) (*sql.DB, error) {
+	return openSQLite()
+}, func(db *sql.DB) {
+	db.Close()
+})
+
+func writeSQLite(ctx context.Context, pts []*client.Point) error {
+	return sqliteConn.use(ctx, func(db *sql.DB) error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		tables := map[string][]*client.Point{}
+		for _, pt := range pts {
+			tables[pt.Name()] = append(tables[pt.Name()], pt)
+		}
+		for _, table := range sortedKeys(tables) {
+			if err := insertSQLite(tx, table, tables[table]); err != nil {
+				return err
+			}
+			if *sqliteRetentionPtr > 0 {
+				_, err = tx.Exec("DELETE FROM "+sqliteIdentifier(table)+" WHERE time < ?", time.Now().Add(-*sqliteRetentionPtr).Unix())
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+func insertSQLite(tx *sql.Tx, table string, pts []*client.Point) error {
+	name := sqliteIdentifier(table)
+	_, err := tx.Exec("CREATE TABLE IF NOT EXISTS " + name + " (time INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE INDEX IF NOT EXISTS " + sqliteIdentifier(table+"_time") + " ON " + name + " (time)")
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return err
+		}
+		existing[column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, pt := range pts {
+		row, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		for k, v := range pt.Tags() {
+			row[k] = v
+		}
+
+		columns := []string{"time"}
+		placeholders := []string{"?"}
+		values := []interface{}{pt.Time().Unix()}
+		for _, column := range sortedKeys(row) {
+			if !existing[column] {
+				_, err = tx.Exec("ALTER TABLE " + name + " ADD COLUMN " + sqliteIdentifier(column) + " " + sqliteType(row[column]))
+				if err != nil {
+					return err
+				}
+				existing[column] = true
+			}
+			columns = append(columns, sqliteIdentifier(column))
+			placeholders = append(placeholders, "?")
+			values = append(values, row[column])
+		}
+		_, err = tx.Exec("INSERT INTO "+name+" ("+strings.Join(columns, ", ")+") VALUES ("+strings.Join(placeholders, ", ")+")", values...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printSQLiteLatest prints the latest row of each table
+func printSQLiteLatest() error {
+	db, err := openSQLite()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tables := []string{}
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return err
+		}
+		tables = append(tables, table)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		rows, err := db.Query("SELECT * FROM " + sqliteIdentifier(table) + " WHERE time = (SELECT max(time) FROM " + sqliteIdentifier(table) + ")")
+		if err != nil {
+			return err
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			ptrs := make([]interface{}, len(columns))
+			for i := range values {
+				ptrs[i] = &values[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				return err
+			}
+
+			line := table
+			for i, column := range columns {
+				if values[i] != nil {
+					line += fmt.Sprintf(" %s=%v", column, values[i])
+				}
+			}
+			fmt.Println(line)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+func sqliteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func sqliteType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "REAL"
+	case int64, bool:
+		return "INTEGER"
+	}
+	return "TEXT"
+}
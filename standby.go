@@ -0,0 +1,47 @@
+// Standby consumption of the PV system itself
+
+// At night the production meter reads slightly negative, which is the Envoy,
+// inverters and any batteries drawing standby power from the grid.  A "standby"
+// reading is written every run (zero while producing) so that integrating it
+// over a month gives the standby energy used.
+
+package main
+
+import (
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"time"
+)
+
+func standbyPoint(prodReadings Eim) (*client.Point, error) {
+	watts := 0.0
+	if prodReadings.WNow < 0 {
+		watts = -prodReadings.WNow
+	}
+	return readingPoint("standby", map[string]interface{}{"watts": watts}, time.Unix(prodReadings.ReadingTime, 0))
+}
+
+// standbyReport prints the standby energy for each of the last 12 months
+func standbyReport() error {
+	c, err := newInfluxClient()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	thisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	measurement, field, where := powerSeries("standby")
+	for i := 11; i >= 0; i-- {
+		from := thisMonth.AddDate(0, -i, 0)
+		to := from.AddDate(0, 1, 0)
+		cmd := fmt.Sprintf(`SELECT integral(%q, 1h) FROM %q WHERE %s AND time >= '%s' AND time < '%s'`,
+			field, measurement, where, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+		wh, _, err := queryFloat(c, cmd)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %.2f kWh\n", from.Format("2006-01"), wh/1000)
+	}
+	return nil
+}
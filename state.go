@@ -0,0 +1,113 @@
+// Persistent state
+
+// With -state-file, when each series was last collected and when each type of
+// event was last written are kept in a file, so a restarted daemon or the next
+// run from cron doesn't write points or events again that it already has, and
+// knows how long it's been since it last collected.  Lifetime counters are
+// kept too, to notice them being reset (see counters.go), as are the grid
+// energy and CO2 avoided counted today (see grid.go and carbon.go) and the
+// inverters offline (see inverters.go).  So is the id of the last event from
+// the Envoy's own log written (see envoyEvents.go).
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var stateFilePtr = flag.String("state-file", "", "File to keep when each series and event was last written in, across restarts and runs")
+
+type persistentState struct {
+	Series   map[string]time.Time       `json:"series"`    // Time of the last point collected for each series
+	Events   map[string]time.Time       `json:"events"`    // Time of the last event written of each type
+	Counters map[string]lifetimeCounter `json:"counters"`  // Lifetime counters of each type of reading
+	Grid     gridEnergy                 `json:"grid"`      // Grid energy imported and exported today
+	Carbon   carbonAvoided              `json:"carbon"`    // CO2 avoided today
+	Offline  map[string]time.Time       `json:"offline"`   // When each inverter offline last reported
+	EnvoyLog int64                      `json:"envoy_log"` // Id of the last event from the Envoy's log written
+}
+
+var (
+	state = persistentState{
+		Series:   map[string]time.Time{},
+		Events:   map[string]time.Time{},
+		Counters: map[string]lifetimeCounter{},
+	}
+	stateMu sync.Mutex
+)
+
+func loadState() error {
+	if *stateFilePtr == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(*stateFilePtr)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state.Series == nil {
+		state.Series = map[string]time.Time{}
+	}
+	if state.Events == nil {
+		state.Events = map[string]time.Time{}
+	}
+	if state.Counters == nil {
+		state.Counters = map[string]lifetimeCounter{}
+	}
+	return nil
+}
+
+// saveState writes the state file, with stateMu held
+func saveState() {
+	if *stateFilePtr == "" || *dryRunPtr {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		// Replace the file whole, so it's never left half written
+		tmp := *stateFilePtr + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, *stateFilePtr)
+		}
+	}
+	if err != nil {
+		log.Printf(journalPriority(4, "Writing state file: %v"), err)
+	}
+}
+
+// lastCollected is when anything was last collected, or zero if never
+func lastCollected() time.Time {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	last := time.Time{}
+	for _, t := range state.Series {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// newEvent notes an event about to be written, returning false if one of its
+// type has already been written at the same or a later time
+func newEvent(e busEvent) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if last, ok := state.Events[e.Type]; ok && !e.Time.After(last) {
+		return false
+	}
+	state.Events[e.Type] = e.Time
+	saveState()
+	return true
+}
@@ -0,0 +1,97 @@
+// StatsD output
+
+// Sends each numeric field as a gauge named <prefix><measurement>.<field>, with
+// the point's tags in the -statsd-format dialect:
+//   dogstatsd  envoy.readings.watts:2977.73|g|#type:production
+//   telegraf   envoy.readings.watts,type=production:2977.73|g
+//   plain      envoy.readings.production.watts:2977.73|g
+// Each point's gauges go in one UDP datagram.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	statsdAddrPtr   = flag.String("statsd-addr", "localhost:8125", "StatsD/DogStatsD UDP address")
+	statsdPrefixPtr = flag.String("statsd-prefix", "envoy.", "Prefix for StatsD metric names")
+	statsdFormatPtr = flag.String("statsd-format", "dogstatsd", "StatsD tag format: dogstatsd, telegraf or plain (tags in the name)")
+)
+
+func writeStatsD(ctx context.Context, pts []*client.Point) error {
+	conn, err := (&net.Dialer{Timeout: time.Second * 10}).DialContext(ctx, "udp", *statsdAddrPtr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return err
+		}
+		tags := pt.Tags()
+		var packet strings.Builder
+		for _, k := range sortedKeys(fields) {
+			value, ok := numericValue(fields[k])
+			if !ok {
+				continue
+			}
+			name, suffix, err := statsdName(pt.Name(), k, tags)
+			if err != nil {
+				return err
+			}
+			// A signed gauge value is a relative change, so negative values are
+			// sent as a reset to 0 then a decrement
+			if value < 0 {
+				fmt.Fprintf(&packet, "%s:0|g%s\n", name, suffix)
+			}
+			fmt.Fprintf(&packet, "%s:%s|g%s\n", name, strconv.FormatFloat(value, 'f', -1, 64), suffix)
+		}
+		if packet.Len() > 0 {
+			_, err = conn.Write([]byte(packet.String()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// statsdName returns a gauge's name and the suffix after its value, carrying the
+// tags as the -statsd-format dialect does
+func statsdName(measurement, field string, tags map[string]string) (string, string, error) {
+	name := *statsdPrefixPtr + measurement
+	keys := sortedKeys(tags)
+	switch *statsdFormatPtr {
+	case "dogstatsd":
+		pairs := make([]string, len(keys))
+		for i, k := range keys {
+			pairs[i] = k + ":" + tags[k]
+		}
+		if len(pairs) == 0 {
+			return name + "." + field, "", nil
+		}
+		return name + "." + field, "|#" + strings.Join(pairs, ","), nil
+	case "telegraf":
+		name += "." + field
+		for _, k := range keys {
+			name += "," + k + "=" + tags[k]
+		}
+		return name, "", nil
+	case "plain":
+		for _, k := range keys {
+			name += "." + tags[k]
+		}
+		return name + "." + field, "", nil
+	}
+	return "", "", configError("unknown -statsd-format %q", *statsdFormatPtr)
+}
@@ -0,0 +1,104 @@
+// Health and status endpoint
+
+// With -status-listen, a daemon serves /healthz, which is 200 OK only while
+// every collector last succeeded within -health-max-age (503 otherwise), and
+// /status, which reports each collector's and output's last success and error
+// counts, circuit breaker states and a summary of the configuration, as JSON
+// for uptime monitors.  With -api-keys both need a key with the status scope
+// (see apiKeys.go).
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var statusListenPtr = flag.String("status-listen", "", "In daemon mode, serve /healthz and /status on this address, e.g. :9102")
+
+var daemonStarted time.Time
+
+type daemonStatus struct {
+	Version      string                     `json:"version"`
+	Started      time.Time                  `json:"started"`
+	EnvoyBreaker string                     `json:"envoy_breaker"`
+	Collectors   map[string]collectorHealth `json:"collectors"`
+	Outputs      map[string]outputStatus    `json:"outputs"`
+	Config       statusConfig               `json:"config"`
+}
+
+type statusConfig struct {
+	Envoy              string            `json:"envoy"`
+	Interval           string            `json:"interval,omitempty"`
+	Schedule           string            `json:"schedule,omitempty"`
+	CollectorIntervals map[string]string `json:"collector_intervals,omitempty"`
+	Outputs            []string          `json:"outputs"`
+}
+
+// serveStatus starts serving /healthz and /status in the background
+func serveStatus() error {
+	daemonStarted = time.Now()
+	if *statusListenPtr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", requireScope("status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := unhealthy(healthSnapshot()); err != nil {
+			http.Error(w, redactSecrets(err.Error()), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "OK")
+	})))
+	mux.Handle("/status", requireScope("status", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(currentStatus())
+	})))
+
+	ln, err := net.Listen("tcp", *statusListenPtr)
+	if err != nil {
+		return fmt.Errorf("serving status: %w", err)
+	}
+	log.Printf("Serving health and status on %s", *statusListenPtr)
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func currentStatus() daemonStatus {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	status := daemonStatus{
+		Version:      versionString(),
+		Started:      daemonStarted,
+		EnvoyBreaker: string(envoyBreaker.current()),
+		Collectors:   healthSnapshot(),
+		Outputs:      map[string]outputStatus{},
+		Config: statusConfig{
+			Envoy:    *envoyHostPtr,
+			Schedule: *schedulePtr,
+			Outputs:  strings.Split(*outputPtr, ","),
+		},
+	}
+	if *intervalPtr > 0 {
+		status.Config.Interval = intervalPtr.String()
+	}
+	for _, name := range sortedKeys(collectorIntervals) {
+		if interval := ownInterval(name); interval > 0 {
+			if status.Config.CollectorIntervals == nil {
+				status.Config.CollectorIntervals = map[string]string{}
+			}
+			status.Config.CollectorIntervals[name] = interval.String()
+		}
+	}
+	for _, o := range enabledOutputs {
+		status.Outputs[o.name] = o.status()
+	}
+	return status
+}
@@ -0,0 +1,52 @@
+// Sunrise/sunset aware polling
+
+// Given the site's -latitude and -longitude, a daemon collects every -interval
+// while the sun is up (from civil dawn to dusk, when panels can produce) and
+// only every -night-interval at night, waking at dawn if that comes first.
+// This saves polling the Envoy and writing points that are all zero
+// production overnight.
+
+package main
+
+import (
+	"flag"
+	"github.com/nathan-osman/go-sunrise"
+	"time"
+)
+
+var (
+	latitudePtr      = flag.Float64("latitude", 0, "Site latitude, for polling less often at night in daemon mode")
+	longitudePtr     = flag.Float64("longitude", 0, "Site longitude, for polling less often at night in daemon mode")
+	nightIntervalPtr = flag.Duration("night-interval", time.Minute*15, "With -latitude/-longitude, how often a daemon collects between dusk and dawn")
+)
+
+// Sun elevation in degrees below which it's night, the end of civil twilight
+const nightElevation = -6.0
+
+func solarAware() bool {
+	return *latitudePtr != 0 || *longitudePtr != 0
+}
+
+// solarNext gives when to collect after a collection started at t
+func solarNext(t time.Time) time.Time {
+	if sunrise.Elevation(*latitudePtr, *longitudePtr, t) > nightElevation {
+		return afterInterval(t, *intervalPtr)
+	}
+	next := afterInterval(t, *nightIntervalPtr)
+	if dawn := nextDawn(t); !dawn.IsZero() && dawn.Before(next) {
+		return dawn
+	}
+	return next
+}
+
+// nextDawn is the next civil dawn after t, or zero during polar night or day
+func nextDawn(t time.Time) time.Time {
+	for d := 0; d < 2; d++ {
+		day := t.UTC().AddDate(0, 0, d)
+		dawn, _ := sunrise.TimeOfElevation(*latitudePtr, *longitudePtr, nightElevation, day.Year(), day.Month(), day.Day())
+		if dawn.After(t) {
+			return dawn
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,90 @@
+// systemd integration
+
+// Under a Type=notify unit, a daemon tells systemd when it's ready, what it's
+// doing and when it's stopping.  With WatchdogSec set, it pings the watchdog
+// while collections are completing, so systemd restarts it if one hangs.  When
+// logging to the journal, errors and warnings carry their syslog priority.
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	cycleStarted   time.Time // When the running collection started, zero between collections
+	cycleStartedMu sync.Mutex
+)
+
+// sdNotify sends a state such as READY=1 to systemd, if it's listening
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("systemd notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Printf("systemd notify: %v", err)
+	}
+}
+
+// startWatchdog pings systemd's watchdog at half its timeout, unless a
+// collection has been running for longer than the timeout
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	timeout := time.Duration(usec) * time.Microsecond
+	go func() {
+		for range time.Tick(timeout / 2) {
+			cycleStartedMu.Lock()
+			started := cycleStarted
+			cycleStartedMu.Unlock()
+			if started.IsZero() || time.Since(started) < timeout {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+func setCycleStarted(t time.Time) {
+	cycleStartedMu.Lock()
+	cycleStarted = t
+	cycleStartedMu.Unlock()
+}
+
+// underJournal is whether stderr is the journal, which adds its own timestamps
+// and reads a <priority> prefix on each line
+func underJournal() bool {
+	return os.Getenv("JOURNAL_STREAM") != ""
+}
+
+// Whether log messages carry a <priority> prefix, for the journal or the
+// Windows event log
+var logPriorities = false
+
+// journalPriority prefixes a log message with its syslog priority when logging
+// to the journal, e.g. 3 for errors and 4 for warnings
+func journalPriority(priority int, format string) string {
+	if !logPriorities {
+		return format
+	}
+	return "<" + strconv.Itoa(priority) + ">" + format
+}
@@ -0,0 +1,157 @@
+// Energy cost and revenue
+
+// With -import-tariff and -export-tariff, net consumption readings also get
+// what the energy imported from the grid since the last reading cost,
+// grid_import_cost, and what the energy exported earned, grid_export_credit,
+// with their totals since local midnight, grid_import_cost_today and
+// grid_export_credit_today, counted along with the energy (see grid.go).  A
+// tariff is a price per kWh, e.g. 0.30, optionally followed by the prices of
+// times of day, on some days of the week, e.g.
+// > -import-tariff 0.12,16:00-21:00=0.45,mon-fri 07:00-16:00=0.25
+// The first window a time falls in sets its price.  The energy between
+// readings is priced at the time halfway between them.  -carbon-intensity is
+// given the same way (see carbon.go).
+
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	importTariffPtr = flag.String("import-tariff", "", "Price per kWh imported from the grid, flat or by time of day, e.g. 0.12,16:00-21:00=0.45,mon-fri 07:00-16:00=0.25 (see README)")
+	exportTariffPtr = flag.String("export-tariff", "", "Credit per kWh exported to the grid, as -import-tariff")
+	currencyPtr     = flag.String("currency", "USD", "Currency of -import-tariff and -export-tariff, for Home Assistant")
+)
+
+// tariff is a price per kWh, by time of day
+type tariff struct {
+	price   float64
+	windows []tariffWindow
+}
+
+// tariffWindow is a price from one time of day to another, on some days
+type tariffWindow struct {
+	days       [7]bool // By time.Weekday
+	start, end int     // Minutes after midnight
+	price      float64
+}
+
+var importTariff, exportTariff *tariff
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// setupTariffs parses -import-tariff and -export-tariff
+func setupTariffs() error {
+	var err error
+	if importTariff, err = parseTariff("import-tariff", *importTariffPtr); err != nil {
+		return err
+	}
+	exportTariff, err = parseTariff("export-tariff", *exportTariffPtr)
+	return err
+}
+
+func parseTariff(name, value string) (*tariff, error) {
+	if value == "" {
+		return nil, nil
+	}
+	items := strings.Split(value, ",")
+	price, err := strconv.ParseFloat(strings.TrimSpace(items[0]), 64)
+	if err != nil {
+		return nil, configError("invalid -%s %q, give the amount per kWh first", name, items[0])
+	}
+	t := &tariff{price: price}
+	for _, item := range items[1:] {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, configError("invalid -%s window %q, use [days ]HH:MM-HH:MM=price", name, item)
+		}
+		w, err := parseTariffWindow(strings.TrimSpace(parts[0]))
+		if err == nil {
+			w.price, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if err != nil {
+			return nil, configError("invalid -%s window %q: %v", name, item, err)
+		}
+		t.windows = append(t.windows, w)
+	}
+	return t, nil
+}
+
+// parseTariffWindow parses e.g. "mon-fri 07:00-16:00" or "22:00-06:00"
+func parseTariffWindow(s string) (tariffWindow, error) {
+	var w tariffWindow
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, configError("use [days ]HH:MM-HH:MM")
+	}
+	for i := range w.days {
+		w.days[i] = len(fields) == 1
+	}
+	if len(fields) == 2 {
+		days := strings.SplitN(strings.ToLower(fields[0]), "-", 2)
+		first, last := indexOf(weekdayNames, days[0]), indexOf(weekdayNames, days[len(days)-1])
+		if first < 0 || last < 0 {
+			return w, configError("unknown days %q, use e.g. mon-fri or sat", fields[0])
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	times := strings.SplitN(fields[len(fields)-1], "-", 2)
+	if len(times) != 2 {
+		return w, configError("use HH:MM-HH:MM")
+	}
+	for i, minutes := range []*int{&w.start, &w.end} {
+		t, err := time.Parse("15:04", times[i])
+		if err != nil {
+			return w, err
+		}
+		*minutes = t.Hour()*60 + t.Minute()
+	}
+	return w, nil
+}
+
+// at is the price per kWh at a time
+func (t *tariff) at(when time.Time) float64 {
+	when = when.Local()
+	minutes := when.Hour()*60 + when.Minute()
+	for _, w := range t.windows {
+		day := when.Weekday()
+		in := minutes >= w.start && minutes < w.end
+		if w.end <= w.start {
+			// Over midnight, so on the day it started
+			in = minutes >= w.start || minutes < w.end
+			if minutes < w.end {
+				day = (day + 6) % 7
+			}
+		}
+		if in && w.days[day] {
+			return w.price
+		}
+	}
+	return t.price
+}
+
+// cost is what energy in Wh costs at a time, or zero without a tariff
+func (t *tariff) cost(wh float64, when time.Time) float64 {
+	if t == nil {
+		return 0
+	}
+	return wh / 1000 * t.at(when)
+}
+
+func indexOf(list []string, s string) int {
+	for i, item := range list {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
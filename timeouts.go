@@ -0,0 +1,41 @@
+// Timeouts
+
+// Each Envoy request is limited to -envoy-timeout (inverter queries can be
+// slow) and each InfluxDB request to -influx-timeout.  With -cycle-timeout, a
+// whole collection, including writing to outputs and retrying, is also cut
+// short at its deadline, as requests take the collection's context, passed down
+// from collecting to writing each output.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+)
+
+var (
+	envoyTimeoutPtr  = flag.Duration("envoy-timeout", time.Second*2, "Time limit for each Envoy request, including reading the response")
+	influxTimeoutPtr = flag.Duration("influx-timeout", time.Second*20, "Time limit for each InfluxDB request")
+	cycleTimeoutPtr  = flag.Duration("cycle-timeout", 0, "Time limit for a whole collection, including writing to outputs and retries (0 for none)")
+)
+
+// withCycleDeadline runs a collection with a context which ends at
+// -cycle-timeout
+func withCycleDeadline(collect func(context.Context) error) error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if *cycleTimeoutPtr > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *cycleTimeoutPtr)
+	}
+	defer cancel()
+	return collect(ctx)
+}
+
+// withinDeadline shortens a timeout to the time left before ctx's deadline,
+// for waits which don't take a context
+func withinDeadline(ctx context.Context, timeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		return time.Until(deadline)
+	}
+	return timeout
+}
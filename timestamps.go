@@ -0,0 +1,53 @@
+// Timestamp policy
+
+// Points are normally timestamped with the Envoy's own reading time, but an
+// Envoy with a wrong clock, or stale report times, puts them in the wrong place.
+// -timestamps collector stamps them with when they were collected instead, and
+// -timestamps both keeps the Envoy's time, adding when they were collected as
+// a collected_at field (Unix seconds).  Collection times are truncated to
+// -precision.
+
+package main
+
+import (
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"time"
+)
+
+var timestampsPtr = flag.String("timestamps", "device", "What to timestamp points with: device (the Envoy's reading time), collector (when collected) or both (device, with a collected_at field)")
+
+func setupTimestamps() error {
+	switch *timestampsPtr {
+	case "device", "collector", "both":
+	default:
+		return configError("unknown -timestamps %q, use device, collector or both", *timestampsPtr)
+	}
+	return nil
+}
+
+// applyTimestamps restamps points collected at collected, as -timestamps says
+func applyTimestamps(pts []*client.Point, collected time.Time) ([]*client.Point, error) {
+	if *timestampsPtr == "device" {
+		return pts, nil
+	}
+	collected = collected.Truncate(precision())
+	stamped := make([]*client.Point, 0, len(pts))
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		t := pt.Time()
+		if *timestampsPtr == "collector" {
+			t = collected
+		} else {
+			fields["collected_at"] = collected.Unix()
+		}
+		if pt, err = newPoint(pt.Name(), pt.Tags(), fields, t); err != nil {
+			return nil, err
+		}
+		stamped = append(stamped, pt)
+	}
+	return stamped, nil
+}
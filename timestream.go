@@ -0,0 +1,110 @@
+// AWS Timestream output
+
+// Writes each point as a multi-measure record named after its measurement, with
+// its tags as dimensions and each field as a measure, e.g.
+//   type=production  measure_name=readings  time=...  watts=2977.73  wh_today=5210.0
+// Credentials come from the standard AWS credential chain, e.g. an EC2/ECS IAM
+// role, AWS_PROFILE or AWS_ACCESS_KEY_ID, and the client is kept from one
+// write to the next (see outputConns.go).  The database and table must exist.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/timestreamwrite"
+	"github.com/aws/aws-sdk-go-v2/service/timestreamwrite/types"
+	"github.com/influxdata/influxdb/client/v2"
+	"strconv"
+)
+
+var (
+	timestreamDatabasePtr = flag.String("timestream-database", "envoy", "AWS Timestream database")
+	timestreamTablePtr    = flag.String("timestream-table", "readings", "AWS Timestream table")
+)
+
+// Timestream accepts at most this many records per WriteRecords call
+const timestreamBatch = 100
+
+var timestreamConn = newOutputConn(func(ctx context.Context) (*timestreamwrite.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return timestreamwrite.NewFromConfig(cfg), nil
+}, func(*timestreamwrite.Client) {})
+
+func writeTimestream(ctx context.Context, pts []*client.Point) error {
+	return timestreamConn.use(ctx, func(svc *timestreamwrite.Client) error {
+		return writeTimestreamRecords(ctx, svc, pts)
+	})
+}
+
+func writeTimestreamRecords(ctx context.Context, svc *timestreamwrite.Client, pts []*client.Point) error {
+	records := make([]types.Record, 0, len(pts))
+	for _, pt := range pts {
+		record, err := timestreamRecord(pt)
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+	for start := 0; start < len(records); start += timestreamBatch {
+		end := start + timestreamBatch
+		if end > len(records) {
+			end = len(records)
+		}
+		_, err := svc.WriteRecords(ctx, &timestreamwrite.WriteRecordsInput{
+			DatabaseName: aws.String(*timestreamDatabasePtr),
+			TableName:    aws.String(*timestreamTablePtr),
+			Records:      records[start:end],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func timestreamRecord(pt *client.Point) (types.Record, error) {
+	tags := pt.Tags()
+	dimensions := []types.Dimension{}
+	for _, k := range sortedKeys(tags) {
+		if tags[k] != "" {
+			dimensions = append(dimensions, types.Dimension{Name: aws.String(k), Value: aws.String(tags[k])})
+		}
+	}
+
+	fields, err := pt.Fields()
+	if err != nil {
+		return types.Record{}, err
+	}
+	measures := []types.MeasureValue{}
+	for _, k := range sortedKeys(fields) {
+		var value string
+		var valueType types.MeasureValueType
+		switch v := fields[k].(type) {
+		case float64:
+			value, valueType = strconv.FormatFloat(v, 'f', -1, 64), types.MeasureValueTypeDouble
+		case int64:
+			value, valueType = strconv.FormatInt(v, 10), types.MeasureValueTypeBigint
+		case bool:
+			value, valueType = strconv.FormatBool(v), types.MeasureValueTypeBoolean
+		default:
+			value, valueType = fmt.Sprint(v), types.MeasureValueTypeVarchar
+		}
+		measures = append(measures, types.MeasureValue{Name: aws.String(k), Value: aws.String(value), Type: valueType})
+	}
+
+	return types.Record{
+		Dimensions:       dimensions,
+		MeasureName:      aws.String(pt.Name()),
+		MeasureValueType: types.MeasureValueTypeMulti,
+		MeasureValues:    measures,
+		Time:             aws.String(strconv.FormatInt(pt.Time().UnixNano()/1e6, 10)),
+		TimeUnit:         types.TimeUnitMilliseconds,
+	}, nil
+}
@@ -0,0 +1,178 @@
+// Validating configuration
+
+// The config validate command reads the -config file and flags, with secrets
+// from the environment, and reports every problem it finds with them without
+// contacting the Envoy, outputs, Vault or AWS, so a change can be checked before
+// it's deployed to a headless box.  It fails with the config exit code (see
+// exitCodes.go) if there are any problems, but not for warnings.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"os"
+	"strings"
+)
+
+// Settings which take one of a few values
+var configChoices = map[string][]string{
+	"carbon-api":     {"", "electricitymaps", "watttime"},
+	"exec-format":    {"json", "lineprotocol"},
+	"influx-version": {"1", "2", "3"},
+	"kafka-format":   {"json", "avro"},
+	"kafka-sasl":     {"", "plain", "scram-sha-256", "scram-sha-512"},
+	"out-of-bounds":  {"drop", "clamp"},
+	"schema":         {"1", "2"},
+	"statsd-format":  {"dogstatsd", "telegraf", "plain"},
+	"timestamps":     {"device", "collector", "both"},
+	"vm-format":      {"import", "influx"},
+	"write-order":    {"relaxed", "strict"},
+}
+
+// Settings each output needs
+var outputSettings = map[string][]string{
+	"adx":              {"adx-cluster"},
+	"emoncms":          {"emoncms-key"},
+	"exec":             {"exec-command"},
+	"influx-secondary": {"secondary-dba"},
+	"pvoutput":         {"pvoutput-key", "pvoutput-system"},
+	"s3":               {"s3-bucket"},
+	"webhook":          {"webhook-urls"},
+}
+
+// Settings which are lists of key=value mappings
+var mappingSettings = []string{"openhab-items", "pvoutput-extended", "rename-fields", "rename-measurements", "rename-tags", "type-measurements", "webhook-headers"}
+
+func configCommand(args []string) error {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	switch args[0] {
+	case "validate":
+		return validateConfig()
+	case "init":
+		return configInit(args[1:])
+	}
+	return configError("unknown config command, use config validate or config init")
+}
+
+func validateConfig() error {
+	problems := []string{}
+	warnings := []string{}
+	problem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	warn := func(format string, a ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, a...))
+	}
+	// Problems found by the code which sets up with the configuration
+	checked := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	checked(secretsFromEnv())
+	if *vaultAddrPtr != "" || os.Getenv("VAULT_ADDR") != "" {
+		warn("secrets in Vault aren't read, so aren't checked")
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Value.String(), awsSecretsManagerScheme) || strings.HasPrefix(f.Value.String(), awsParameterStoreScheme) {
+			warn("-%s is in AWS, so isn't checked", f.Name)
+		}
+	})
+
+	for _, name := range sortedKeys(configChoices) {
+		value := flag.Lookup(name).Value.String()
+		if !contains(configChoices[name], value) {
+			problem("-%s %q isn't one of %s", name, value, strings.Join(configChoices[name], ", "))
+		}
+	}
+	for _, name := range mappingSettings {
+		_, err := mappingList(name, flag.Lookup(name).Value.String())
+		checked(err)
+	}
+	checked(setupBounds())
+	checked(setupCollect())
+	checked(setupPrecision())
+	checked(setupTariffs())
+	checked(setupCarbon())
+	checked(setupAPIKeys())
+
+	// Intervals
+	flag.VisitAll(func(f *flag.Flag) {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if d, ok := getter.Get().(interface{ Seconds() float64 }); ok && d.Seconds() < 0 {
+				problem("-%s %s is negative", f.Name, f.Value)
+			}
+		}
+	})
+	if *schedulePtr != "" {
+		if _, err := cron.ParseStandard(*schedulePtr); err != nil {
+			problem("-schedule: %v", err)
+		}
+		if *intervalPtr > 0 {
+			warn("both -interval and -schedule are given, -schedule is used")
+		}
+	}
+	if *intervalPtr > 0 && *intervalPtr < *envoyTimeoutPtr {
+		warn("-interval %s is shorter than -envoy-timeout %s, so slow collections will run late", *intervalPtr, *envoyTimeoutPtr)
+	}
+	if *intervalPtr > 0 && *cycleTimeoutPtr > *intervalPtr {
+		warn("-cycle-timeout %s is longer than -interval %s, so collections can run late", *cycleTimeoutPtr, *intervalPtr)
+	}
+	if *maxStretchPtr < 1 {
+		problem("-max-stretch %d is less than 1, use 1 to always collect at the interval", *maxStretchPtr)
+	}
+	if *latitudePtr < -90 || *latitudePtr > 90 || *longitudePtr < -180 || *longitudePtr > 180 {
+		problem("-latitude %g -longitude %g isn't a place", *latitudePtr, *longitudePtr)
+	}
+
+	// Outputs
+	for _, name := range strings.Split(*outputPtr, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := outputs[name]; !ok {
+			problem("unknown output %q", name)
+			continue
+		}
+		for _, setting := range outputSettings[name] {
+			if flag.Lookup(setting).Value.String() == "" {
+				problem("the %s output needs -%s", name, setting)
+			}
+		}
+	}
+	if *influxVersionPtr == 2 && (*influxOrgPtr == "" || *influxTokenPtr == "") {
+		problem("-influx-version 2 needs -influx-org and -influx-token")
+	}
+	if *influxVersionPtr == 3 && *influxTokenPtr == "" {
+		problem("-influx-version 3 needs -influx-token")
+	}
+	if *mqttCAPtr != "" {
+		if _, err := os.Stat(*mqttCAPtr); err != nil {
+			problem("-mqtt-ca: %v", err)
+		}
+	}
+
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	for _, p := range problems {
+		fmt.Printf("Problem: %s\n", p)
+	}
+	if len(problems) > 0 {
+		return configError("%d problems with the configuration", len(problems))
+	}
+	fmt.Println("Configuration OK")
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,302 @@
+// Credentials from HashiCorp Vault
+
+// When a Vault address is configured, credentials are read at startup from a KV
+// version 2 secret, e.g.
+//   vault kv put secret/envoy envoy_token=... db_user=... db_password=...
+// or influx_token=... for InfluxDB 2.
+// Authentication is with VAULT_TOKEN, or AppRole with VAULT_ROLE_ID and
+// VAULT_SECRET_ID (each also accepting a _FILE variant).  The connection is
+// configured from the environment as for the vault CLI, e.g. VAULT_CACERT for
+// a private CA.  A daemon keeps its token renewed as long as Vault allows,
+// reading the secret again each time so that rotated credentials are used,
+// and once the token can't be renewed any further logs in again.  The secret
+// is read again on reloading too (see reload.go), logging in again if Vault
+// refuses the token by then, reading VAULT_TOKEN (or its file, which an agent
+// may have rotated) again or with AppRole.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/hashicorp/vault/api"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Flags set from each key of the Vault secret
+var vaultSecretKeys = map[string]string{
+	"envoy_password": "envoy-pw",
+	"envoy_token":    "envoy-token",
+	"db_user":        "dbu",
+	"db_password":    "dbp",
+	"influx_token":   "influx-token",
+	"mqtt_password":  "mqtt-pw",
+}
+
+// Flags set from Vault
+var vaultSecretFlags = map[string]bool{}
+
+var (
+	// The client, made when the secret is first read
+	vaultClient *api.Client
+	// The token from the last login, for renewing it
+	vaultAuth *api.SecretAuth
+)
+
+// How long to wait before trying to log in to Vault again after failing, or
+// for VAULT_TOKEN to be replaced
+const vaultRetryWait = time.Minute
+
+func secretsFromVault(ctx context.Context) error {
+	if vaultClient == nil {
+		c, err := newVaultClient()
+		if err != nil || c == nil {
+			return err
+		}
+		vaultClient = c
+	}
+
+	mount, path := *vaultSecretPtr, ""
+	if i := strings.Index(mount, "/"); i >= 0 {
+		mount, path = mount[:i], mount[i+1:]
+	}
+	secret, err := vaultReadSecret(ctx, mount, path)
+	if err != nil {
+		return err
+	}
+
+	set := explicitFlags()
+	for key, name := range vaultSecretKeys {
+		value, ok := secret[key].(string)
+		if ok && !set[name] && envFlags[name] == "" {
+			if err := flag.Set(name, value); err != nil {
+				return configError("Vault secret %s: %v", key, err)
+			}
+			vaultSecretFlags[name] = true
+		}
+	}
+	return nil
+}
+
+// newVaultClient makes a client for -vault-addr or VAULT_ADDR, configured from
+// the environment, or returns nil if there's no address
+func newVaultClient() (*api.Client, error) {
+	if *vaultAddrPtr == "" && os.Getenv("VAULT_ADDR") == "" {
+		return nil, nil
+	}
+	// DefaultConfig reads the environment, VAULT_CACERT and the like
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, configError("Vault: %v", config.Error)
+	}
+	if *vaultAddrPtr != "" {
+		config.Address = *vaultAddrPtr
+	}
+	config.Timeout = time.Second * 5
+	c, err := api.NewClient(config)
+	if err != nil {
+		return nil, configError("Vault: %v", err)
+	}
+	// Set from VAULT_TOKEN or AppRole on logging in
+	c.ClearToken()
+	return c, nil
+}
+
+// vaultReadSecret reads the secret with the token from the last login,
+// logging in if there's none or Vault refuses it
+func vaultReadSecret(ctx context.Context, mount, path string) (map[string]interface{}, error) {
+	if vaultAuth != nil {
+		secret, err := vaultClient.KVv2(mount).Get(ctx, path)
+		if !vaultForbidden(err) {
+			if err != nil {
+				return nil, fmt.Errorf("reading the Vault secret: %w", err)
+			}
+			return secret.Data, nil
+		}
+		log.Print("Vault refused its token, logging in again")
+	}
+	auth, err := vaultLogin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("logging in to Vault: %w", err)
+	}
+	vaultClient.SetToken(auth.ClientToken)
+	vaultAuth = auth
+	secret, err := vaultClient.KVv2(mount).Get(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("reading the Vault secret: %w", err)
+	}
+	return secret.Data, nil
+}
+
+// vaultForbidden is whether Vault refused a request's token
+func vaultForbidden(err error) bool {
+	var response *api.ResponseError
+	return errors.As(err, &response) && response.StatusCode == http.StatusForbidden
+}
+
+// vaultLogin logs in, with AppRole if configured or else VAULT_TOKEN
+func vaultLogin(ctx context.Context) (*api.SecretAuth, error) {
+	roleId, ok, err := secretFromEnv("VAULT_ROLE_ID")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		token, _, err := secretFromEnv("VAULT_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		registerSecret(token)
+		// Its lease is looked up if it's to be renewed
+		return &api.SecretAuth{ClientToken: token}, nil
+	}
+	secretId, _, err := secretFromEnv("VAULT_SECRET_ID")
+	if err != nil {
+		return nil, err
+	}
+	registerSecret(secretId)
+
+	login, err := vaultClient.Logical().WriteWithContext(ctx, "auth/approle/login", map[string]interface{}{
+		"role_id":   roleId,
+		"secret_id": secretId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if login == nil || login.Auth == nil {
+		return nil, errors.New("no token in the AppRole login")
+	}
+	registerSecret(login.Auth.ClientToken)
+	return login.Auth, nil
+}
+
+// vaultTokenLease looks up whether a token given with VAULT_TOKEN can be
+// renewed, and how soon it expires
+func vaultTokenLease(ctx context.Context, auth *api.SecretAuth) (*api.SecretAuth, error) {
+	self, err := vaultClient.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := self.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+	renewable, err := self.TokenIsRenewable()
+	if err != nil {
+		return nil, err
+	}
+	return &api.SecretAuth{ClientToken: auth.ClientToken, Renewable: renewable, LeaseDuration: int(ttl.Seconds())}, nil
+}
+
+// renewVault keeps a daemon's Vault token renewed until stopped, reading the
+// secret again on each renewal, and logs in again once it can't be renewed
+func renewVault(ctx context.Context) {
+	if vaultClient == nil {
+		return
+	}
+	go func() {
+		for {
+			settingsMu.RLock()
+			auth := vaultAuth
+			settingsMu.RUnlock()
+			if auth != nil && auth.LeaseDuration == 0 {
+				var err error
+				if auth, err = vaultTokenLease(ctx, auth); err != nil {
+					log.Printf(journalPriority(3, "Looking up the Vault token: %v"), redactSecrets(err.Error()))
+					return
+				}
+			}
+			// e.g. a root token, which doesn't expire
+			if auth == nil || auth.LeaseDuration == 0 {
+				return
+			}
+			if auth.Renewable {
+				watcher, err := vaultClient.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: &api.Secret{Auth: auth}})
+				if err != nil {
+					log.Printf(journalPriority(3, "Renewing the Vault token: %v"), err)
+					return
+				}
+				go watcher.Start()
+				if !watchVaultToken(ctx, watcher) {
+					return
+				}
+			} else if !sleepUntil(ctx, time.Now().Add(time.Duration(auth.LeaseDuration)*time.Second*2/3)) {
+				return
+			}
+
+			// Renewed as far as Vault allows, so log in again, waiting
+			// for VAULT_TOKEN to be replaced if it's the same one
+			for {
+				err := refreshVaultSecrets(ctx, true)
+				settingsMu.RLock()
+				replaced := vaultAuth != nil && vaultAuth.ClientToken != auth.ClientToken
+				settingsMu.RUnlock()
+				if err == nil && replaced {
+					break
+				}
+				if !sleepUntil(ctx, time.Now().Add(vaultRetryWait)) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// watchVaultToken reads the secret again each time the token's renewed,
+// returning true once it can't be renewed, or false if stopped first
+func watchVaultToken(ctx context.Context, watcher *api.LifetimeWatcher) bool {
+	defer watcher.Stop()
+	for {
+		select {
+		case <-watcher.RenewCh():
+			if *verbosePtr {
+				log.Print("Renewed the Vault token")
+			}
+			refreshVaultSecrets(ctx, false)
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				log.Printf(journalPriority(4, "Renewing the Vault token failed: %v"), redactSecrets(err.Error()))
+			}
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// refreshVaultSecrets reads the secret again between collections, logging in
+// first if asked to, and has the outputs connect again if it's changed
+func refreshVaultSecrets(ctx context.Context, login bool) error {
+	cycleMu.Lock()
+	defer cycleMu.Unlock()
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+
+	before := flagValues()
+	if login {
+		vaultAuth = nil
+	}
+	if err := secretsFromVault(ctx); err != nil {
+		log.Printf(journalPriority(3, "Reading the Vault secret again failed: %v"), redactSecrets(err.Error()))
+		return err
+	}
+	changed := []string{}
+	for name := range vaultSecretFlags {
+		if flag.Lookup(name).Value.String() != before[name] {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		log.Printf("Vault secret changed %s", strings.Join(changed, ", "))
+		closeInfluxClients()
+		closeOutputConns()
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Version
+
+// Release builds set the version, commit and build date with -ldflags, e.g.
+// > go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+// Otherwise they're taken from what Go records of the build, where it can.
+// The version is printed by the version command and logged when a daemon
+// starts, and with -version-tag is a collector_version tag on every point, to
+// tell data written by different versions apart.  The schema is the
+// version of the measurements, tags and fields written (see schemaV2.go).
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/influxdata/influxdb/client/v2"
+	"runtime"
+	"runtime/debug"
+)
+
+var versionTagPtr = flag.Bool("version-tag", false, "Tag every point with collector_version, the version of this collector which wrote it")
+
+// Set with -ldflags -X
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "" && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if commit == "" {
+				commit = setting.Value
+			}
+		case "vcs.time":
+			if buildDate == "" {
+				buildDate = setting.Value
+			}
+		}
+	}
+	if version == "" {
+		version = "(devel)"
+	}
+}
+
+// versionString describes the version for logs, e.g. 1.4.0 (a1b2c3d, built 2024-05-01T10:00:00Z)
+func versionString() string {
+	s := version
+	if commit != "" {
+		s += " (" + commit
+		if buildDate != "" {
+			s += ", built " + buildDate
+		}
+		s += ")"
+	}
+	return s
+}
+
+func printVersion() {
+	fmt.Printf("influxEnvoyStats %s\n", versionString())
+	fmt.Printf("schema %d (of up to %d), %s %s/%s\n", *schemaPtr, latestSchema, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// tagVersion tags points with collector_version, with -version-tag
+func tagVersion(pts []*client.Point) ([]*client.Point, error) {
+	if !*versionTagPtr {
+		return pts, nil
+	}
+	tagged := make([]*client.Point, 0, len(pts))
+	for _, pt := range pts {
+		fields, err := pt.Fields()
+		if err != nil {
+			return nil, err
+		}
+		tags := pt.Tags()
+		tags["collector_version"] = version
+		if pt, err = newPoint(pt.Name(), tags, fields, pt.Time()); err != nil {
+			return nil, err
+		}
+		tagged = append(tagged, pt)
+	}
+	return tagged, nil
+}
@@ -0,0 +1,74 @@
+// VictoriaMetrics output
+
+// Writes either JSON lines to /api/v1/import, or line protocol to the InfluxDB
+// compatible /write endpoint, gzipped.  Series are named as VictoriaMetrics names
+// InfluxDB data, <measurement>_<field> with the tags as labels, so both formats
+// give the same series, e.g.
+//   readings_watts{type="production"}
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"strings"
+)
+
+var (
+	vmUrlPtr    = flag.String("vm-url", "http://localhost:8428", "VictoriaMetrics URL")
+	vmFormatPtr = flag.String("vm-format", "import", "VictoriaMetrics write format: \"import\" for /api/v1/import JSON lines, or \"influx\" for /write line protocol")
+)
+
+func writeVictoriaMetrics(ctx context.Context, pts []*client.Point) error {
+	var body bytes.Buffer
+	path, contentType := "/api/v1/import", "application/json"
+	if *vmFormatPtr == "influx" {
+		path, contentType = "/write?precision=ms", "text/plain"
+		for _, pt := range pts {
+			body.WriteString(pt.PrecisionString("ms") + "\n")
+		}
+	} else {
+		enc := json.NewEncoder(&body)
+		for _, pt := range pts {
+			fields, err := pt.Fields()
+			if err != nil {
+				return err
+			}
+			for _, field := range sortedKeys(fields) {
+				value, ok := numericValue(fields[field])
+				if !ok {
+					continue
+				}
+				labels := map[string]string{"__name__": pt.Name() + "_" + field}
+				for k, v := range pt.Tags() {
+					labels[k] = v
+				}
+				err := enc.Encode(map[string]interface{}{
+					"metric":     labels,
+					"values":     []float64{value},
+					"timestamps": []int64{pt.Time().UnixNano() / 1e6},
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	gzipped, err := gzipBody(body.Bytes())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(*vmUrlPtr, "/")+path, gzipped)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", "gzip")
+	_, err = doOutputRequest(ctx, req)
+	return err
+}
@@ -0,0 +1,91 @@
+// Webhook output
+
+// POSTs each run's readings to one or more URLs as a JSON document of the same
+// objects as the jsonl output:
+//   {"time":"2018-12-15T03:05:50Z","readings":[{"time":...,"measurement":"readings","tags":{...},"fields":{...}}, ...]}
+// Header values may reference environment variables as ${NAME}, to keep tokens
+// out of ps.  With -webhook-secret the body is signed GitHub style, in an
+// X-Signature-256 header of sha256=<hex HMAC-SHA256 of the body>.  Events
+// (see bus.go) are POSTed to the same URLs as they happen, e.g.
+//   {"time":"2018-12-15T03:05:50Z","event":{"time":...,"kind":"grid state","type":"grid","title":"Exporting to the grid","text":...}}
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"github.com/influxdata/influxdb/client/v2"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	webhookURLsPtr    = flag.String("webhook-urls", "", "Comma separated URLs to POST readings to")
+	webhookHeadersPtr = flag.String("webhook-headers", "", "Comma separated Name=value headers for webhooks, ${NAME} is replaced from the environment")
+	webhookSecretPtr  = flag.String("webhook-secret", "", "Secret to sign webhook bodies with (or WEBHOOK_SECRET / WEBHOOK_SECRET_FILE)")
+)
+
+type webhookBody struct {
+	Time     string     `json:"time"`
+	Readings []jsonLine `json:"readings"`
+}
+
+type webhookEventBody struct {
+	Time  string    `json:"time"`
+	Event eventJSON `json:"event"`
+}
+
+func writeWebhooks(ctx context.Context, pts []*client.Point) error {
+	payload := webhookBody{Time: time.Now().UTC().Format(time.RFC3339Nano), Readings: []jsonLine{}}
+	for _, pt := range pts {
+		line, err := newJSONLine(pt)
+		if err != nil {
+			return err
+		}
+		payload.Readings = append(payload.Readings, line)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postWebhooks(ctx, body)
+}
+
+func postWebhookEvent(ctx context.Context, e busEvent) error {
+	body, err := json.Marshal(webhookEventBody{Time: time.Now().UTC().Format(time.RFC3339Nano), Event: newEventJSON(e)})
+	if err != nil {
+		return err
+	}
+	return postWebhooks(ctx, body)
+}
+
+// postWebhooks POSTs a body to each of -webhook-urls
+func postWebhooks(ctx context.Context, body []byte) error {
+	headers := mapping("webhook-headers")
+	for _, url := range strings.Split(*webhookURLsPtr, ",") {
+		req, err := http.NewRequest(http.MethodPost, strings.TrimSpace(url), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, os.ExpandEnv(value))
+		}
+		if *webhookSecretPtr != "" {
+			mac := hmac.New(sha256.New, []byte(*webhookSecretPtr))
+			mac.Write(body)
+			req.Header.Set("X-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		if _, err := doOutputRequest(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
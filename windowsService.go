@@ -0,0 +1,157 @@
+// Windows service
+
+// `influxEnvoyStats <flags> service install` installs a service which runs a
+// daemon with the same flags (so they need -interval or -schedule), logging to
+// the Windows event log.  `service start`, `service stop` and
+// `service uninstall` manage it.
+
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+	"log"
+	"os"
+	"strings"
+)
+
+const serviceName = "influxEnvoyStats"
+
+func isWindowsService() (bool, error) {
+	return svc.IsWindowsService()
+}
+
+func runWindowsService() error {
+	elog, err := eventlog.Open(serviceName)
+	if err != nil {
+		return fmt.Errorf("opening the event log: %w", err)
+	}
+	defer elog.Close()
+	log.SetOutput(redactingWriter{eventLogWriter{elog}})
+	log.SetFlags(0)
+	logPriorities = true
+
+	if !daemonMode() {
+		return configError("the service needs -interval or -schedule")
+	}
+	return svc.Run(serviceName, windowsService{})
+}
+
+type windowsService struct{}
+
+func (windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- runDaemon(ctx)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf(journalPriority(3, "Stopped: %v"), err)
+				return true, uint32(exitCode(err))
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
+
+// eventLogWriter logs each message to the event log, at the level of its
+// <priority> prefix
+type eventLogWriter struct {
+	elog *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimRight(string(p), "\n")
+	var err error
+	switch {
+	case strings.HasPrefix(msg, "<3>"):
+		err = w.elog.Error(1, msg[3:])
+	case strings.HasPrefix(msg, "<4>"):
+		err = w.elog.Warning(1, msg[3:])
+	default:
+		err = w.elog.Info(1, msg)
+	}
+	return len(p), err
+}
+
+func serviceCommand(command string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if command == "install" {
+		if !daemonMode() {
+			return configError("install the service with -interval or -schedule")
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		// The service runs with the flags given with "service install"
+		args := flagArgs
+		s, err := m.CreateService(serviceName, exe, mgr.Config{
+			DisplayName: "Enphase Envoy monitoring",
+			Description: "Collects Enphase Envoy readings",
+			StartType:   mgr.StartAutomatic,
+		}, args...)
+		if err != nil {
+			return err
+		}
+		s.Close()
+		if err := eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+			return err
+		}
+		fmt.Printf("Installed service %s\n", serviceName)
+		return nil
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	switch command {
+	case "start":
+		if err := s.Start(); err != nil {
+			return err
+		}
+	case "stop":
+		_, err = s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+	case "uninstall":
+		if err := s.Delete(); err != nil {
+			return err
+		}
+		if err := eventlog.Remove(serviceName); err != nil {
+			return err
+		}
+	default:
+		return configError("unknown service command %q, use install, start, stop or uninstall", command)
+	}
+	fmt.Printf("Service %s: %s\n", serviceName, command)
+	return nil
+}